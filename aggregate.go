@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// Aggregate escalates arrests to the prefix when many distinct ips
+// of the same prefix are arrested within a duration.
+type Aggregate struct {
+	// IPv4 and IPv6 are prefix lengths, 0 disables aggregation of the family.
+	IPv4   int           `yaml:"ipv4"`
+	IPv6   int           `yaml:"ipv6"`
+	Min    int           `yaml:"min"`
+	Within time.Duration `yaml:"within"`
+
+	// ips counts distinct ips by prefix.
+	ips Distinct
+
+	arrestPrefixCount *Counter
+}
+
+type aggregateYAML struct {
+	IPv4   int           `yaml:"ipv4"`
+	IPv6   int           `yaml:"ipv6"`
+	Min    int           `yaml:"min"`
+	Within time.Duration `yaml:"within"`
+}
+
+func (a *Aggregate) UnmarshalYAML(b []byte) error {
+	v := aggregateYAML{IPv4: 24, IPv6: 64, Within: time.Hour}
+	if err := YamlDecode(b, &v); err != nil {
+		return err
+	}
+	if v.IPv4 < 0 || v.IPv4 > 32 {
+		return fmt.Errorf("bad aggregate ipv4 prefix: %d", v.IPv4)
+	}
+	if v.IPv6 < 0 || v.IPv6 > 128 {
+		return fmt.Errorf("bad aggregate ipv6 prefix: %d", v.IPv6)
+	}
+	if v.Min < 2 {
+		return fmt.Errorf("aggregate min must be at least 2: %d", v.Min)
+	}
+	if v.Within < time.Millisecond {
+		return fmt.Errorf("bad aggregate within: %s", v.Within)
+	}
+	a.IPv4 = v.IPv4
+	a.IPv6 = v.IPv6
+	a.Min = v.Min
+	a.Within = v.Within
+	a.ips.Group = "ip"
+	a.ips.Min = v.Min
+	a.ips.Within = v.Within
+	return nil
+}
+
+func (a *Aggregate) MarshalYAML() (any, error) {
+	return aggregateYAML{IPv4: a.IPv4, IPv6: a.IPv6, Min: a.Min, Within: a.Within}, nil
+}
+
+func (a *Aggregate) String() string {
+	return fmt.Sprintf("%d ips/%s", a.Min, formatDuration(a.Within))
+}
+
+// Init registers counters of discipline id, it's a no-op on nil.
+func (a *Aggregate) Init(id string) {
+	if a == nil {
+		return
+	}
+	a.arrestPrefixCount = RegisterNewCounter("discipline", id, "arrest_prefix")
+}
+
+// Prefix returns the prefix of ip, nil if aggregation of its family is disabled.
+func (a *Aggregate) Prefix(ip net.IP) *net.IPNet {
+	bits, ones := 128, a.IPv6
+	if v4 := ip.To4(); v4 != nil {
+		ip, bits, ones = v4, 32, a.IPv4
+	}
+	if ones == 0 {
+		return nil
+	}
+	mask := net.CIDRMask(ones, bits)
+	return &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+}
+
+// AddAt records ip arrested at now, returns its prefix when distinct ips
+// of the prefix within the duration reach Min, the prefix is reset then.
+// A nil Aggregate never escalates.
+func (a *Aggregate) AddAt(ip net.IP, now time.Time) *net.IPNet {
+	if a == nil {
+		return nil
+	}
+	prefix := a.Prefix(ip)
+	if prefix == nil {
+		return nil
+	}
+	key := prefix.String()
+	if a.ips.AddAt(key, ip.String(), now) < a.Min {
+		return nil
+	}
+	a.ips.Reset(key)
+	a.arrestPrefixCount.Incr()
+	return prefix
+}
+
+func (a *Aggregate) Stop() {
+	if a == nil {
+		return
+	}
+	a.ips.Stop()
+}
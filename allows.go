@@ -0,0 +1,434 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/goccy/go-yaml"
+)
+
+// Allow sources besides literal cidrs and ips.
+const (
+	allowFile = "file:"
+	allowURL  = "url:"
+	allowDNS  = "dns:"
+)
+
+var allowRefreshes = map[string]time.Duration{
+	allowFile: time.Minute,
+	allowURL:  time.Hour,
+	allowDNS:  5 * time.Minute,
+}
+
+// maxIPListSize bounds body of ip list urls.
+const maxIPListSize = 16 << 20
+
+// Networks of url sources shorter than these are dropped,
+// so a tampered list could not allow the whole internet.
+const (
+	minURLAllowBits4 = 8
+	minURLAllowBits6 = 16
+)
+
+// AllowEntry is a cidr, an ip or a source of them:
+// file:<path> of one cidr per line, url:<https url> of text or json,
+// dns:<name> of A and AAAA records.
+// Sources are reloaded every Refresh, plain http urls require Insecure.
+type AllowEntry struct {
+	Source   string        `yaml:"source"`
+	Refresh  time.Duration `yaml:"refresh,omitempty"`
+	Insecure bool          `yaml:"insecure,omitempty"`
+}
+
+func (e AllowEntry) MarshalYAML() (any, error) {
+	if e.Refresh == 0 && !e.Insecure {
+		return e.Source, nil
+	}
+	type alias AllowEntry
+	return alias(e), nil
+}
+
+// UnmarshalYAML accepts "10.0.0.0/8" or {source: url:https://..., refresh: 1h}.
+func (e *AllowEntry) UnmarshalYAML(b []byte) error {
+	var s string
+	if err := yaml.Unmarshal(b, &s); err == nil {
+		*e = AllowEntry{Source: s}
+		return nil
+	}
+	type alias AllowEntry
+	var v alias
+	if err := YamlDecode(b, &v); err != nil {
+		return err
+	}
+	if v.Refresh < 0 {
+		return fmt.Errorf("bad allow refresh: %s", v.Refresh)
+	}
+	*e = AllowEntry(v)
+	return nil
+}
+
+// Allows are trusted networks, lookups are done by a prefix trie.
+// Copies of Allows share the same networks.
+type Allows struct {
+	set *allowSet
+}
+
+type allowSet struct {
+	entries []AllowEntry
+	static  *IPSet
+	sources []*allowSource
+	set     atomic.Pointer[IPSet]
+	started atomic.Bool
+
+	mu     sync.Mutex
+	cancel func()
+	wg     sync.WaitGroup
+}
+
+type allowSource struct {
+	AllowEntry
+	kind   string
+	target string
+	nets   []netip.Prefix
+}
+
+// NewAllows parses entries, sources are not loaded until Start.
+func NewAllows(entries ...AllowEntry) (Allows, error) {
+	s := &allowSet{static: NewIPSet()}
+	for _, e := range entries {
+		if slices.ContainsFunc(s.entries, func(v AllowEntry) bool { return v.Source == e.Source }) {
+			continue
+		}
+		kind, target, ok := cutAllowSource(e.Source)
+		if !ok {
+			p, err := ParsePrefix(e.Source)
+			if err != nil {
+				return Allows{}, fmt.Errorf("bad ipcidr: %s, %w", e.Source, err)
+			}
+			s.static.Add(p)
+		} else {
+			if err := checkAllowSource(kind, target, e.Insecure); err != nil {
+				return Allows{}, fmt.Errorf("bad allow %s: %w", e.Source, err)
+			}
+			if e.Refresh == 0 {
+				e.Refresh = allowRefreshes[kind]
+			}
+			s.sources = append(s.sources, &allowSource{AllowEntry: e, kind: kind, target: target})
+		}
+		s.entries = append(s.entries, e)
+	}
+	s.rebuild()
+	return Allows{set: s}, nil
+}
+
+func cutAllowSource(s string) (kind, target string, ok bool) {
+	for k := range allowRefreshes {
+		if v, found := strings.CutPrefix(s, k); found {
+			return k, strings.TrimSpace(v), true
+		}
+	}
+	return "", "", false
+}
+
+// checkAllowSource checks target of kind, plain http urls are rejected unless insecure.
+func checkAllowSource(kind, target string, insecure bool) error {
+	if target == "" {
+		return fmt.Errorf("empty source")
+	}
+	switch kind {
+	case allowURL:
+		u, err := url.Parse(target)
+		if err != nil {
+			return err
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("unsupported url scheme: %s", u.Scheme)
+		}
+		if u.Scheme == "http" && !insecure {
+			return fmt.Errorf("plain http url could be tampered, use https or set insecure")
+		}
+	case allowDNS:
+		if strings.ContainsAny(target, " /:") {
+			return fmt.Errorf("bad dns name: %s", target)
+		}
+	}
+	return nil
+}
+
+// Contains reports whether ip is trusted, loopback and unspecified ips are always trusted.
+func (a Allows) Contains(ip net.IP) bool {
+	addr, ok := AddrFromIP(ip)
+	return ok && a.ContainsAddr(addr)
+}
+
+// ContainsAddr likes Contains but accepts a netip.Addr.
+func (a Allows) ContainsAddr(addr netip.Addr) bool {
+	if addr.IsLoopback() || addr.IsUnspecified() {
+		return true
+	}
+	if a.set == nil {
+		return false
+	}
+	return a.set.set.Load().Contains(addr)
+}
+
+var alwaysAllowed = []netip.Addr{
+	netip.IPv4Unspecified(), netip.MustParseAddr("127.0.0.1"),
+	netip.IPv6Unspecified(), netip.IPv6Loopback(),
+}
+
+// Overlaps reports whether any trusted ip is in p, or p is in a trusted network.
+func (a Allows) Overlaps(p netip.Prefix) bool {
+	p = normalizePrefix(p)
+	if a.ContainsAddr(p.Addr()) || slices.ContainsFunc(alwaysAllowed, p.Contains) {
+		return true
+	}
+	return a.set != nil && a.set.set.Load().Overlaps(p)
+}
+
+// Entries returns entries of allows as configured.
+func (a Allows) Entries() []AllowEntry {
+	if a.set == nil {
+		return nil
+	}
+	return a.set.entries
+}
+
+// Merge returns allows of entries of both a and b.
+func (a Allows) Merge(b Allows) (Allows, error) {
+	return NewAllows(append(append([]AllowEntry{}, a.Entries()...), b.Entries()...)...)
+}
+
+// Start loads sources and reloads them in the background, it's safe to call many times.
+// Sources are loaded synchronously, so it's called on start-up instead of judging lines.
+func (a Allows) Start(logger Logger) {
+	s := a.set
+	if s == nil || len(s.sources) == 0 || s.started.Load() {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started.Load() {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	for _, src := range s.sources {
+		if nets, ok := s.load(ctx, src, logger); ok {
+			src.nets = nets
+		}
+	}
+	s.rebuild()
+	for _, src := range s.sources {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			tick := time.NewTicker(src.Refresh)
+			defer tick.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-tick.C:
+					// load without the lock, Stop never waits for a slow source.
+					nets, ok := s.load(ctx, src, logger)
+					if !ok {
+						continue
+					}
+					s.mu.Lock()
+					src.nets = nets
+					s.rebuild()
+					s.mu.Unlock()
+				}
+			}
+		}()
+	}
+	s.started.Store(true)
+}
+
+func (a Allows) Stop() {
+	s := a.set
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	cancel := s.cancel
+	s.cancel = nil
+	s.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+	s.wg.Wait()
+}
+
+// load reports false when source fails, networks of last successful load are kept.
+func (s *allowSet) load(ctx context.Context, src *allowSource, logger Logger) ([]netip.Prefix, bool) {
+	var (
+		nets []netip.Prefix
+		err  error
+	)
+	switch src.kind {
+	case allowFile:
+		var b []byte
+		if b, err = os.ReadFile(src.target); err == nil {
+			nets = ParseIPList(b)
+		}
+	case allowURL:
+		if nets, err = fetchIPList(ctx, src.target); err == nil {
+			nets = slices.DeleteFunc(nets, func(p netip.Prefix) bool {
+				short := p.Bits() < minURLAllowBits4 || p.Addr().Is6() && p.Bits() < minURLAllowBits6
+				if short {
+					logger.Errorf("[allows] drop too short network of %s: %s", src.Source, p)
+				}
+				return short
+			})
+		}
+	case allowDNS:
+		nets, err = resolveIPList(ctx, src.target)
+	}
+	if err != nil {
+		logger.Errorf("[allows] load %s fail: %v", src.Source, err)
+		return nil, false
+	}
+	logger.Debugf("[allows] load %s: %d networks", src.Source, len(nets))
+	return nets, true
+}
+
+func (s *allowSet) rebuild() {
+	t := NewIPSet()
+	t.Merge(s.static)
+	for _, src := range s.sources {
+		for _, p := range src.nets {
+			t.Add(p)
+		}
+	}
+	s.set.Store(t)
+}
+
+func fetchIPList(ctx context.Context, u string) ([]netip.Prefix, error) {
+	b, err := fetchURL(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	return ParseIPList(b), nil
+}
+
+func fetchURL(ctx context.Context, u string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("http status code %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxIPListSize))
+}
+
+func resolveIPList(ctx context.Context, name string) ([]netip.Prefix, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	var nets []netip.Prefix
+	for _, addr := range addrs {
+		if a, ok := AddrFromIP(addr.IP); ok {
+			nets = append(nets, netip.PrefixFrom(a, a.BitLen()))
+		}
+	}
+	return nets, nil
+}
+
+// ParseIPList parses cidrs and ips of b.
+// JSON documents are walked for all string values of cidrs and ips, e.g. published ranges of providers.
+// Otherwise b is text of one entry per line, the first field of a line is used,
+// comments starting with # or ; are ignored.
+func ParseIPList(b []byte) []netip.Prefix {
+	var nets []netip.Prefix
+	if json.Valid(b) {
+		var v any
+		if err := json.Unmarshal(b, &v); err == nil {
+			walkJSONStrings(v, func(s string) {
+				if p, err := ParsePrefix(s); err == nil {
+					nets = append(nets, p)
+				}
+			})
+			return nets
+		}
+	}
+	scan := bufio.NewScanner(bytes.NewReader(b))
+	for scan.Scan() {
+		line := scan.Text()
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ' ' || r == '\t' || r == ','
+		})
+		if len(fields) == 0 {
+			continue
+		}
+		if p, err := ParsePrefix(fields[0]); err == nil {
+			nets = append(nets, p)
+		}
+	}
+	return nets
+}
+
+func walkJSONStrings(v any, fn func(string)) {
+	switch v := v.(type) {
+	case string:
+		fn(v)
+	case []any:
+		for _, e := range v {
+			walkJSONStrings(e, fn)
+		}
+	case map[string]any:
+		for _, e := range v {
+			walkJSONStrings(e, fn)
+		}
+	}
+}
+
+func (a Allows) MarshalYAML() (any, error) {
+	return a.Entries(), nil
+}
+
+func (a *Allows) UnmarshalYAML(b []byte) error {
+	var entries []AllowEntry
+	if err := yaml.Unmarshal(b, &entries); err != nil {
+		return err
+	}
+	v, err := NewAllows(entries...)
+	if err != nil {
+		return err
+	}
+	r, err := a.Merge(v)
+	if err != nil {
+		return err
+	}
+	*a = r
+	return nil
+}
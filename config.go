@@ -3,10 +3,12 @@ package main
 import (
 	"errors"
 	"fmt"
+	"maps"
 	"net"
 	"regexp"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/goccy/go-yaml"
 )
@@ -38,6 +40,20 @@ func RegisterDiscipliner(name string, bu DisciplineBuilder) {
 type Line struct {
 	WatchID string
 	Text    string
+	// Time is the event time of line, zero means unknown.
+	Time time.Time
+	// Replay reports that line is read from history,
+	// windows of disciplines are counted on event time instead of wall clock.
+	Replay bool
+}
+
+// At returns the time windows count line at,
+// the event time of replayed lines, otherwise the wall clock.
+func (l Line) At() time.Time {
+	if l.Replay && !l.Time.IsZero() {
+		return l.Time
+	}
+	return time.Now()
 }
 
 func NewLine(watchID string, text string) Line {
@@ -48,8 +64,11 @@ func NewLine(watchID string, text string) Line {
 }
 
 type BaseWatch struct {
-	ID   string `yaml:"id"`
-	Type string `yaml:"type"`
+	ID        string     `yaml:"id"`
+	Type      string     `yaml:"type"`
+	Multiline *Multiline `yaml:"multiline,omitempty"`
+	// Replay counts rates on event time, it's useful to watch archived logs.
+	Replay bool `yaml:"replay,omitempty"`
 }
 
 type Watch struct {
@@ -131,6 +150,15 @@ type BadLog struct {
 	IP           net.IP
 	Extend       KeyValueList
 	IPLocation   string
+	// Accomplices are other ips arrested together with IP,
+	// e.g. ips contributed to a rate keyed by user.
+	Accomplices []net.IP
+	// Time is the event time of line, zero means unknown.
+	Time time.Time
+	// Replay reports that line is read from history.
+	Replay bool
+	// Prefix is set when the arrest is escalated to the whole prefix of IP.
+	Prefix *net.IPNet
 }
 
 func NewBadLog(line Line, disciplineID string, ip net.IP, extend ...KeyValue) BadLog {
@@ -140,21 +168,54 @@ func NewBadLog(line Line, disciplineID string, ip net.IP, extend ...KeyValue) Ba
 		DisciplineID: disciplineID,
 		IP:           ip,
 		Extend:       extend,
+		Time:         line.Time,
+		Replay:       line.Replay,
 	}
 }
 
+// At returns the time windows count the arrest at, see Line.At.
+func (b *BadLog) At() time.Time {
+	return Line{Time: b.Time, Replay: b.Replay}.At()
+}
+
 func (b *BadLog) AsEnv() []string {
 	envs := b.Extend.AsEnv()
 	envs = append(envs, fmt.Sprintf("GO2JAIL_IP_LOCATION=%s", b.IPLocation))
+	if b.Prefix != nil {
+		envs = append(envs, fmt.Sprintf("GO2JAIL_PREFIX=%s", b.Prefix))
+	}
+	if !b.Time.IsZero() {
+		envs = append(envs, fmt.Sprintf("GO2JAIL_TIME=%s", b.Time.Format(time.RFC3339)))
+	}
 	return envs
 }
 
+// Target returns prefix in CIDR notation if the arrest is escalated, otherwise the ip.
+func (b *BadLog) Target() string {
+	if b.Prefix != nil {
+		return b.Prefix.String()
+	}
+	return b.IP.String()
+}
+
 func (b *BadLog) Mapping(s string) string {
 	switch s {
 	case "ip":
 		return b.IP.String()
 	case "ip_location":
 		return b.IPLocation
+	case "prefix":
+		if b.Prefix == nil {
+			return ""
+		}
+		return b.Prefix.String()
+	case "target":
+		return b.Target()
+	case "time":
+		if b.Time.IsZero() {
+			return ""
+		}
+		return b.Time.Format(time.RFC3339)
 	default:
 		return b.Extend.Get(s)
 	}
@@ -176,6 +237,18 @@ type Jailer interface {
 	Close() error
 }
 
+// Releaser is implemented by jails which could release arrested ips.
+type Releaser interface {
+	Release(data BadLog, log Logger) error
+}
+
+// BatchJailer is implemented by jails which could arrest and release many ips at once,
+// feeds use it instead of one call per network.
+type BatchJailer interface {
+	ArrestBatch(bads []BadLog, log Logger) error
+	ReleaseBatch(bads []BadLog, log Logger) error
+}
+
 func (j *Jail) UnmarshalYAML(b []byte) error {
 	if err := yaml.Unmarshal(b, &j.BaseJail); err != nil {
 		return err
@@ -197,11 +270,24 @@ type Discipliner interface {
 	Close() error
 }
 
+// IndexedDiscipliner is a discipline which only judges lines containing one of its literals,
+// the engine skips other lines by a match index shared by all disciplines of a watch.
+type IndexedDiscipliner interface {
+	Discipliner
+	// RequiredLiterals returns folded literals one of which a judged line contains, nil means any line.
+	RequiredLiterals() []string
+	// Skip accounts a line not judged.
+	Skip(line Line)
+}
+
 type BaseDiscipline struct {
 	ID      string  `yaml:"id"`
 	Type    string  `yaml:"type"`
 	Watches Strings `yaml:"watches"`
 	Jails   Strings `yaml:"jails"`
+	Preset  string  `yaml:"preset,omitempty"`
+	// Aggregate escalates arrests of many ips in one prefix to the prefix.
+	Aggregate *Aggregate `yaml:"aggregate,omitempty"`
 }
 
 type Discipline struct {
@@ -216,6 +302,15 @@ func (d *Discipline) UnmarshalYAML(b []byte) error {
 	if d.ID == "" {
 		return errors.New("watch id is empty")
 	}
+	if d.Preset != "" {
+		var err error
+		if b, err = applyPreset(d.Preset, b); err != nil {
+			return fmt.Errorf("[discipline-%s] %w", d.ID, err)
+		}
+		if err := yaml.Unmarshal(b, &d.BaseDiscipline); err != nil {
+			return err
+		}
+	}
 	if d.Type == "" {
 		d.Type = "regex"
 	}
@@ -228,6 +323,7 @@ func (d *Discipline) UnmarshalYAML(b []byte) error {
 		return err
 	}
 	d.Action = p
+	d.Aggregate.Init(d.ID)
 	return nil
 }
 
@@ -237,16 +333,30 @@ type Config struct {
 	Disciplines       []*Discipline     `yaml:"disciplines"`
 	Allows            Allows            `yaml:"allows"`
 	IPLocationSources IPLocationSources `yaml:"ip_location_sources"`
+	Patterns          Patterns          `yaml:"patterns,omitempty"`
+	Feeds             []*Feed           `yaml:"feeds,omitempty"`
 }
 
 func Parse(files ...string) (*Config, error) {
 	var cfg Config
-	for _, f := range files {
-		c, err := parse(f)
-		if err != nil {
-			return nil, err
+	patterns, err := readPatterns(files...)
+	if err != nil {
+		return nil, err
+	}
+	err = WithPatterns(patterns, func() error {
+		for _, f := range files {
+			c, err := parse(f)
+			if err != nil {
+				return err
+			}
+			if err := mergeConfig(&cfg, c); err != nil {
+				return fmt.Errorf("%s: %w", f, err)
+			}
 		}
-		mergeConfig(&cfg, c)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	for _, d := range cfg.Disciplines {
 		for _, id := range d.Watches {
@@ -264,6 +374,31 @@ func Parse(files ...string) (*Config, error) {
 				return nil, fmt.Errorf("[discipline-%s] jail %s not found", d.ID, id)
 			}
 		}
+		if l, ok := d.Action.(ArrestListener); ok {
+			for _, id := range l.Sources() {
+				i := slices.IndexFunc(
+					cfg.Disciplines,
+					func(e *Discipline) bool { return e.ID == id },
+				)
+				if i < 0 {
+					return nil, fmt.Errorf("[discipline-%s] discipline %s not found", d.ID, id)
+				}
+				// arrests of listeners are not listened
+				if _, ok := cfg.Disciplines[i].Action.(ArrestListener); ok {
+					return nil, fmt.Errorf("[discipline-%s] discipline %s listens arrests and can not be listened", d.ID, id)
+				}
+			}
+		}
+	}
+	for _, f := range cfg.Feeds {
+		for _, id := range f.Jails {
+			if !slices.ContainsFunc(
+				cfg.Jails,
+				func(j *Jail) bool { return j.ID == id },
+			) {
+				return nil, fmt.Errorf("[feed-%s] jail %s not found", f.ID, id)
+			}
+		}
 	}
 	return &cfg, nil
 }
@@ -280,7 +415,7 @@ func parse(file string) (*Config, error) {
 	return &cfg, nil
 }
 
-func mergeConfig(dst, src *Config) {
+func mergeConfig(dst, src *Config) error {
 	dst.Jails = appendIf(dst.Jails, src.Jails, func(a, b *Jail) bool {
 		return a.ID == b.ID
 	})
@@ -290,12 +425,24 @@ func mergeConfig(dst, src *Config) {
 	dst.Watches = appendIf(dst.Watches, src.Watches, func(a, b *Watch) bool {
 		return a.ID == b.ID
 	})
-	dst.Allows = appendIf(dst.Allows, src.Allows, func(a, b net.IPNet) bool {
-		return a.IP.Equal(b.IP) && a.Mask.String() == b.Mask.String()
+	allows, err := dst.Allows.Merge(src.Allows)
+	if err != nil {
+		return err
+	}
+	dst.Allows = allows
+	dst.Feeds = appendIf(dst.Feeds, src.Feeds, func(a, b *Feed) bool {
+		return a.ID == b.ID
 	})
+	if src.Patterns != nil {
+		if dst.Patterns == nil {
+			dst.Patterns = Patterns{}
+		}
+		maps.Copy(dst.Patterns, src.Patterns)
+	}
 	dst.IPLocationSources = appendIf(dst.IPLocationSources, src.IPLocationSources, func(a, b *IPLocationSource) bool {
 		return a.ID == b.ID
 	})
+	return nil
 }
 
 func appendIf[T any](dst, src []T, eq func(a, b T) bool) []T {
@@ -311,42 +458,3 @@ LOOP:
 	}
 	return dst
 }
-
-type Allows []net.IPNet
-
-func (a Allows) Contains(ip net.IP) bool {
-	if ip.IsLoopback() || ip.IsUnspecified() {
-		return true
-	}
-	for _, l := range a {
-		if l.Contains(ip) {
-			return true
-		}
-	}
-	return false
-}
-
-func (a Allows) MarshalYAML() (any, error) {
-	var ss []string
-	for _, l := range a {
-		ss = append(ss, l.String())
-	}
-	return ss, nil
-}
-
-func (a *Allows) UnmarshalYAML(b []byte) error {
-	var ss []string
-	if err := yaml.Unmarshal(b, &ss); err != nil {
-		return err
-	}
-	for _, s := range ss {
-		_, cidr, err := net.ParseCIDR(s)
-		if err != nil {
-			return fmt.Errorf("bad ipcidr: %s, %w", s, err)
-		}
-		*a = appendIf(*a, []net.IPNet{*cidr}, func(a, b net.IPNet) bool {
-			return a.IP.Equal(b.IP) && a.Mask.String() == b.Mask.String()
-		})
-	}
-	return nil
-}
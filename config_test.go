@@ -33,7 +33,7 @@ func TestParse(t *testing.T) {
 	require.NoError(t, err)
 	require.Len(t, cfg.Jails, 1)
 	require.Len(t, cfg.Disciplines, 2)
-	require.Len(t, cfg.Allows, 1)
+	require.Len(t, cfg.Allows.Entries(), 1)
 	require.Len(t, cfg.Watches, 1)
 	require.Equal(t, "nft", cfg.Jails[0].ID)
 	require.Equal(t, "discipline", cfg.Disciplines[0].ID)
@@ -82,9 +82,92 @@ func TestParse(t *testing.T) {
 						"discipline type not found in full.yaml: %s", name,
 					)
 				}
-				require.Greater(t, len(cfg.Allows), 0)
+				require.Greater(t, len(cfg.Allows.Entries()), 0)
 				require.Greater(t, len(cfg.IPLocationSources), 0)
 			}
 		})
 	}
 }
+
+func TestPresets(t *testing.T) {
+	testSetStrictConfig(t)
+	samples := map[string]string{
+		"sshd":             "Jan  2 03:04:05 host sshd[1]: Failed password for invalid user bob from 1.2.3.4 port 22 ssh2",
+		"nginx-4xx":        `1.2.3.4 - - [02/Jan/2025:03:04:05 +0800] "GET /admin HTTP/1.1" 403 12 "-" "curl/8.0"`,
+		"nginx-bad-bots":   `1.2.3.4 - - [02/Jan/2025:03:04:05 +0800] "GET / HTTP/1.1" 200 12 "-" "sqlmap/1.7"`,
+		"postfix-sasl":     "postfix/smtpd[1]: warning: unknown[1.2.3.4]: SASL LOGIN authentication failed: UGFzc3dvcmQ6",
+		"dovecot":          "imap-login: Disconnected (auth failed, 1 attempts in 2 secs): user=<bob>, method=PLAIN, rip=1.2.3.4, lip=5.6.7.8",
+		"vsftpd":           `[pid 1] [bob] FAIL LOGIN: Client "::ffff:1.2.3.4"`,
+		"proftpd":          "proftpd[1]: host (example.com[1.2.3.4]) - USER bob (Login failed): Incorrect password",
+		"apache-auth":      "[auth_basic:error] [pid 1] [client 1.2.3.4:5000] AH01617: user bob: authentication failure for \"/\": Password Mismatch",
+		"wordpress-xmlrpc": `1.2.3.4 - - [02/Jan/2025:03:04:05 +0800] "POST /xmlrpc.php HTTP/1.1" 200 12 "-" "-"`,
+		"gitea":            "Failed authentication attempt for bob from 1.2.3.4:5000: invalid credentials",
+		"grafana":          `logger=context msg="Invalid username or password" error="invalid" remote_addr=1.2.3.4`,
+		"openvpn":          "1.2.3.4:5000 TLS Error: TLS handshake failed",
+	}
+	names := PresetNames()
+	require.Len(t, names, len(samples))
+	for _, name := range names {
+		t.Run(name, func(t *testing.T) {
+			b, err := Preset(name)
+			require.NoError(t, err)
+			require.NotEmpty(t, PresetDescription(b))
+			var d Discipline
+			err = YamlDecode([]byte("id: "+name+"\npreset: "+name+"\nrate: 1/s\n"), &d)
+			require.NoError(t, err)
+			defer d.Action.Close()
+			rd, ok := d.Action.(*RegexDiscipline)
+			require.True(t, ok)
+			require.Equal(t, "1/s", rd.Rate.String())
+			sample, ok := samples[name]
+			require.True(t, ok, "no sample of preset")
+			require.Equal(t, "1.2.3.4", rd.Matches.Match(sample).Get("ip"))
+		})
+	}
+	var d Discipline
+	require.Error(t, YamlDecode([]byte("id: x\npreset: nope\n"), &d))
+}
+
+func TestParseRecidiveOfRecidive(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(f, []byte(`
+watches:
+  - id: w
+    type: file
+    files: [/dev/null]
+disciplines:
+  - id: d
+    watches: [w]
+    matches: '%(ip)'
+  - id: r1
+    type: recidive
+    disciplines: [d]
+    rate: 1/m
+  - id: r2
+    type: recidive
+    disciplines: [r1]
+    rate: 1/m
+`), 0o644)
+	require.NoError(t, err)
+	_, err = Parse(f)
+	require.ErrorContains(t, err, "[discipline-r2] discipline r1 listens arrests and can not be listened")
+}
+
+func TestRateJudgeGroups(t *testing.T) {
+	for _, c := range []struct {
+		config, err string
+	}{
+		{"matches: '%(ip) (?P<user>\\w+)'\nrate_key: '${usr}'", `group "usr" of rate_key not found in matches`},
+		{"type: sequence\ncorrelate: pid\nsteps: ['(?P<pid>\\d+) %(ip)', '(?P<pid>\\d+) done']\nrate_key: '${user}'", `group "user" of rate_key not found in steps`},
+		{"type: score\nthreshold: 1\nrules: [{matches: '%(ip)', score: 1}]\nrate_key: '${ip}-${path}'", `group "path" of rate_key not found in rules`},
+		{"type: json\nip: /ip\nrate_key: '${uri}'", `group "uri" of rate_key not found in fields`},
+		{"matches: '%(ip) (?P<user>\\w+)'\ndistinct: {group: usr, min: 2, within: 1m}", `distinct group "usr" not found in matches`},
+	} {
+		var d Discipline
+		err := YamlDecode([]byte("id: rate-judge-groups\n"+c.config), &d)
+		require.ErrorContains(t, err, c.err, c.config)
+	}
+	var d Discipline
+	require.NoError(t, YamlDecode([]byte("id: rate-judge-groups\nmatches: '%(ip) (?P<user>\\w+)'\nrate_key: '${user}'\narrest_all: true"), &d))
+	d.Action.Close()
+}
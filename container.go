@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hpcloud/tail"
+)
+
+func init() {
+	RegisterWatcher("container", NewContainerWatch)
+}
+
+const (
+	defaultContainerRoot = "/var/lib/docker/containers"
+	// docker splits long lines into 16KB partial records, a logical line
+	// bigger than this is flushed anyway.
+	maxContainerLineSize = 1024 * 1024
+)
+
+// ContainerWatch tails logs written by the docker json-file log driver.
+type ContainerWatch struct {
+	BaseWatch      `yaml:",inline"`
+	Root           string        `yaml:"root"`
+	Names          []string      `yaml:"names"`
+	Labels         []string      `yaml:"labels"`
+	Streams        []string      `yaml:"streams"`
+	RescanInterval time.Duration `yaml:"rescan_interval"`
+
+	ctx     context.Context
+	cancel  Finisher
+	wg      sync.WaitGroup
+	wgCount atomic.Int32
+	mu      sync.Mutex
+	tails   map[string]func()
+	// failed are containers of which the tail failed, they are tailed again from end.
+	failed map[string]bool
+
+	linesCounter      *Counter
+	containersCounter *Counter
+}
+
+func NewContainerWatch(decode Decoder) (Watcher, error) {
+	var c ContainerWatch
+	if err := decode(&c); err != nil {
+		return nil, err
+	}
+	if c.Root == "" {
+		c.Root = defaultContainerRoot
+	}
+	if c.RescanInterval <= 0 {
+		c.RescanInterval = time.Second * 10
+	}
+	for _, s := range c.Streams {
+		switch s {
+		case "stdout", "stderr":
+		default:
+			return nil, fmt.Errorf("[watch-%s] unknown stream: %s", c.ID, s)
+		}
+	}
+	for _, l := range c.Labels {
+		if strings.HasPrefix(l, "=") {
+			return nil, fmt.Errorf("[watch-%s] bad label: %s", c.ID, l)
+		}
+	}
+	var cancel func()
+	c.ctx, cancel = context.WithCancel(context.Background())
+	c.cancel.Push(cancel)
+	c.tails = map[string]func(){}
+	c.failed = map[string]bool{}
+	c.linesCounter = RegisterNewCounter("watch", c.ID, "lines")
+	c.containersCounter = RegisterNewCounter("watch", c.ID, "containers")
+	return &c, nil
+}
+
+type containerInfo struct {
+	ID     string `json:"ID"`
+	Name   string `json:"Name"`
+	Config struct {
+		Labels map[string]string `json:"Labels"`
+	} `json:"Config"`
+
+	logPath string
+}
+
+type containerLog struct {
+	Log    string `json:"log"`
+	Stream string `json:"stream"`
+	Time   string `json:"time"`
+}
+
+func (cw *ContainerWatch) Watch(logger Logger) (<-chan Line, error) {
+	return cw.watch(logger, false)
+}
+
+func (cw *ContainerWatch) Test(logger Logger) (<-chan Line, error) {
+	return cw.watch(logger, true)
+}
+
+func (cw *ContainerWatch) watch(logger Logger, testing bool) (<-chan Line, error) {
+	logger.Debugf("[watch-%s] watch starting", cw.ID)
+	if _, err := os.Stat(cw.Root); err != nil {
+		cw.cancel.Finish()
+		return nil, fmt.Errorf("[watch-%s] %w", cw.ID, err)
+	}
+	ch := NewChan[Line](0)
+	cw.cancel.Push(ch.Close)
+	cw.rescan(logger, ch, testing, false)
+	if testing {
+		if cw.wgCount.Load() <= 0 {
+			cw.cancel.Finish()
+		}
+		return ch.Reader(), nil
+	}
+	cw.wg.Add(1)
+	go func() {
+		defer cw.wg.Done()
+		tick := time.NewTicker(cw.RescanInterval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-cw.ctx.Done():
+				return
+			case <-tick.C:
+				cw.rescan(logger, ch, testing, true)
+			}
+		}
+	}()
+	return ch.Reader(), nil
+}
+
+// rescan starts tailing containers which are not watched yet and stops
+// the ones whose directory has been removed.
+func (cw *ContainerWatch) rescan(logger Logger, ch *Chan[Line], testing, fromStart bool) {
+	containers, err := cw.list()
+	if err != nil {
+		logger.Errorf("[watch-%s] list containers fail: %v", cw.ID, err)
+		return
+	}
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	for id, stop := range cw.tails {
+		if !slices.ContainsFunc(containers, func(c *containerInfo) bool {
+			return c.ID == id
+		}) {
+			logger.Infof("[watch-%s] container removed: %s", cw.ID, id)
+			stop()
+			delete(cw.tails, id)
+		}
+	}
+	type started struct {
+		c *containerInfo
+		t *tail.Tail
+	}
+	var tails []started
+	for _, c := range containers {
+		if _, ok := cw.tails[c.ID]; ok {
+			continue
+		}
+		t, err := tailFile(c.logPath, testing, fromStart && !cw.failed[c.ID])
+		if err != nil {
+			logger.Errorf("[watch-%s] tail container %s fail: %v", cw.ID, c.Name, err)
+			continue
+		}
+		delete(cw.failed, c.ID)
+		tails = append(tails, started{c: c, t: t})
+	}
+	// count all tails before any of them starts, so that in testing mode
+	// the first finished tail does not close the channel under the others.
+	cw.wg.Add(len(tails))
+	cw.wgCount.Add(int32(len(tails)))
+	for _, st := range tails {
+		ctx, cancel := context.WithCancel(cw.ctx)
+		cw.tails[st.c.ID] = cancel
+		go cw.follow(ctx, logger, ch, st.t, st.c, testing)
+	}
+}
+
+func (cw *ContainerWatch) follow(ctx context.Context,
+	logger Logger, ch *Chan[Line], t *tail.Tail, c *containerInfo, testing bool) {
+	cw.containersCounter.Incr()
+	defer func() {
+		if ctx.Err() == nil {
+			// the tail ends by itself, forget it so that rescan tails it again.
+			cw.mu.Lock()
+			if stop, ok := cw.tails[c.ID]; ok {
+				stop()
+				delete(cw.tails, c.ID)
+				cw.failed[c.ID] = true
+			}
+			cw.mu.Unlock()
+		}
+		cw.wg.Done()
+		if cw.wgCount.Add(-1) <= 0 && testing {
+			cw.cancel.Finish()
+		}
+		t.Stop()
+		t.Cleanup()
+	}()
+	logger.Debugf("[watch-%s] watch container %s: %s", cw.ID, c.Name, c.logPath)
+	partial := map[string]*strings.Builder{}
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Infof("[watch-%s] container closed: %s", cw.ID, c.Name)
+			return
+		case line, ok := <-t.Lines:
+			if !ok {
+				logger.Infof("[watch-%s] container closed: %s", cw.ID, c.Name)
+				return
+			}
+			if line.Err != nil {
+				logger.Errorf("[watch-%s] tail container fail %s: %v", cw.ID, c.Name, line.Err)
+				return
+			}
+			var entry containerLog
+			if err := json.Unmarshal([]byte(line.Text), &entry); err != nil {
+				logger.Debugf("[watch-%s] bad container log %s: %v", cw.ID, c.Name, err)
+				continue
+			}
+			if len(cw.Streams) > 0 && !slices.Contains(cw.Streams, entry.Stream) {
+				continue
+			}
+			buf := partial[entry.Stream]
+			if buf == nil {
+				buf = &strings.Builder{}
+				partial[entry.Stream] = buf
+			}
+			buf.WriteString(entry.Log)
+			if !strings.HasSuffix(entry.Log, "\n") && buf.Len() < maxContainerLineSize {
+				continue
+			}
+			text := strings.TrimRight(buf.String(), "\r\n")
+			buf.Reset()
+			logger.Debugf("[watch-%s] get line from %s(%s %s): '%s'", cw.ID, c.Name, entry.Stream, entry.Time, text)
+			l := NewLine(cw.ID, text)
+			if t, err := time.Parse(time.RFC3339Nano, entry.Time); err == nil {
+				l.Time = t
+			}
+			if err := ch.Send(l); err != nil {
+				return
+			}
+			cw.linesCounter.Incr()
+		}
+	}
+}
+
+func (cw *ContainerWatch) list() ([]*containerInfo, error) {
+	entries, err := os.ReadDir(cw.Root)
+	if err != nil {
+		return nil, err
+	}
+	var containers []*containerInfo
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		dir := filepath.Join(cw.Root, e.Name())
+		b, err := os.ReadFile(filepath.Join(dir, "config.v2.json"))
+		if err != nil {
+			continue
+		}
+		var c containerInfo
+		if err := json.Unmarshal(b, &c); err != nil {
+			continue
+		}
+		if c.ID == "" {
+			c.ID = e.Name()
+		}
+		c.Name = strings.TrimPrefix(c.Name, "/")
+		c.logPath = filepath.Join(dir, e.Name()+"-json.log")
+		if _, err := os.Stat(c.logPath); err != nil {
+			continue
+		}
+		if cw.match(&c) {
+			containers = append(containers, &c)
+		}
+	}
+	return containers, nil
+}
+
+// match reports whether the container has one of names and all of the labels.
+// Label is either a key or a key=value pair.
+func (cw *ContainerWatch) match(c *containerInfo) bool {
+	if len(cw.Names) > 0 && !slices.ContainsFunc(cw.Names, func(name string) bool {
+		return strings.TrimPrefix(name, "/") == c.Name || name == c.ID
+	}) {
+		return false
+	}
+	for _, l := range cw.Labels {
+		k, v, hasValue := strings.Cut(l, "=")
+		got, ok := c.Config.Labels[k]
+		if !ok || (hasValue && got != v) {
+			return false
+		}
+	}
+	return true
+}
+
+func (cw *ContainerWatch) Close() error {
+	cw.cancel.Finish()
+	cw.wg.Wait()
+	return nil
+}
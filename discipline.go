@@ -3,19 +3,31 @@ package main
 import (
 	"fmt"
 	"net"
+	"os"
+	"time"
 )
 
 func init() {
 	RegisterDiscipliner("regex", NewRegexDiscipline)
 }
 
-type RegexDiscipline struct {
-	BaseDiscipline `yaml:",inline"`
-	Matches        *Matcher `yaml:"matches,omitempty"`
-	Ignores        *Matcher `yaml:"ignores,omitempty"`
-	Rate           *Limiter `yaml:"rate,omitempty"`
-	Allows         Allows   `yaml:"allows"`
+// RateJudge applies allow lists and rate limit to matched lines.
+// It is shared by all disciplines which arrest ip by rate.
+type RateJudge struct {
+	Rate   *Limiter `yaml:"rate,omitempty"`
+	Allows Allows   `yaml:"allows"`
+	// RateKey is a template of named groups the rate is counted on, default is ${ip}.
+	RateKey string `yaml:"rate_key,omitempty"`
+	// ArrestAll arrests all ips hit the rate key within the window,
+	// it only works when rate key is not ip based.
+	ArrestAll bool `yaml:"arrest_all,omitempty"`
+	// Distinct requires ip to hit many distinct values of a group before rate is counted.
+	Distinct *Distinct `yaml:"distinct,omitempty"`
+	// Time extracts event time of line, rates of replayed lines are counted on it.
+	Time *EventTime `yaml:"time,omitempty"`
 
+	id             string
+	keyHasIP       bool
 	tailLinesCount *Counter
 	matchLineCount *Counter
 	badIPLineCount *Counter
@@ -24,6 +36,148 @@ type RegexDiscipline struct {
 	arrestIPCount  *Counter
 }
 
+func (rj *RateJudge) Init(id string) {
+	rj.id = id
+	rj.keyHasIP = rj.RateKey == ""
+	os.Expand(rj.RateKey, func(s string) string {
+		if s == "ip" {
+			rj.keyHasIP = true
+		}
+		return ""
+	})
+	rj.tailLinesCount = RegisterNewCounter("discipline", id, "tail_lines")
+	rj.matchLineCount = RegisterNewCounter("discipline", id, "match_lines")
+	rj.badIPLineCount = RegisterNewCounter("discipline", id, "bad_ip")
+	rj.allowIPCount = RegisterNewCounter("discipline", id, "allow_ip")
+	rj.watchIPCount = RegisterNewCounter("discipline", id, "watch_ip")
+	rj.arrestIPCount = RegisterNewCounter("discipline", id, "arrest_ip")
+}
+
+// CheckGroups checks named groups used by rate key, distinct and time exist,
+// has reports whether the discipline matches the group.
+func (rj *RateJudge) CheckGroups(has func(group string) bool) error {
+	var err error
+	os.Expand(rj.RateKey, func(s string) string {
+		if err == nil && !has(s) {
+			err = fmt.Errorf("group %q of rate_key not found", s)
+		}
+		return ""
+	})
+	if err != nil {
+		return err
+	}
+	if rj.Distinct != nil && !has(rj.Distinct.Group) {
+		return fmt.Errorf("distinct group %q not found", rj.Distinct.Group)
+	}
+	if rj.Time != nil && !has(rj.Time.Group) {
+		return fmt.Errorf("time group %q not found", rj.Time.Group)
+	}
+	return nil
+}
+
+func (rj *RateJudge) Close() error {
+	rj.Rate.Stop()
+	rj.Distinct.Stop()
+	rj.Allows.Stop()
+	return nil
+}
+
+// StartAllows loads sources of allows of the discipline.
+func (rj *RateJudge) StartAllows(logger Logger) {
+	rj.Allows.Start(logger)
+}
+
+func (rj *RateJudge) AllowIP(ip net.IP) bool {
+	return rj.Allows.Contains(ip)
+}
+
+// Tail counts a line read by discipline.
+func (rj *RateJudge) Tail() {
+	rj.tailLinesCount.Incr()
+}
+
+// eventAt sets the event time of line by the time group if it's unknown,
+// and returns the time windows count line at.
+func (rj *RateJudge) eventAt(line Line, groups KeyValueList, logger Logger) (Line, time.Time) {
+	if rj.Time != nil && line.Time.IsZero() {
+		t, err := rj.Time.Parse(groups.Get(rj.Time.Group), time.Now())
+		if err != nil {
+			logger.Debugf("[discipline-%s][watch-%s] bad event time: %v", rj.id, line.WatchID, err)
+		}
+		line.Time = t
+	}
+	return line, line.At()
+}
+
+// Judge decides whether the ip in groups should be arrested.
+// groups must be matched groups of line and contain an ip group.
+func (rj *RateJudge) Judge(line Line, groups KeyValueList, allow Allows, logger Logger) (bad BadLog, ok bool) {
+	rj.matchLineCount.Incr()
+	ip := net.ParseIP(groups.Get("ip"))
+	if ip == nil {
+		logger.Debugf("[discipline-%s][watch-%s] no ip group: %s", rj.id, line.WatchID, groups)
+		rj.badIPLineCount.Incr()
+		return bad, false
+	}
+	if allow.Contains(ip) || rj.Allows.Contains(ip) {
+		rj.allowIPCount.Incr()
+		return bad, false
+	}
+	line, at := rj.eventAt(line, groups, logger)
+	sip := ip.String()
+	if rj.Distinct != nil {
+		n := rj.Distinct.AddAt(sip, groups.Get(rj.Distinct.Group), at)
+		if n < rj.Distinct.Min {
+			rj.watchIPCount.Incr()
+			logger.Infof("[discipline-%s][watch-%s] watch-on(distinct %d<%s): %s %s", rj.id, line.WatchID, n, rj.Distinct, sip, line.Text)
+			return bad, false
+		}
+	}
+	key := sip
+	if rj.RateKey != "" {
+		key = os.Expand(rj.RateKey, func(s string) string {
+			if s == "ip" {
+				return sip
+			}
+			return groups.Get(s)
+		})
+	}
+	var (
+		desc    string
+		members []string
+	)
+	if rj.ArrestAll && !rj.keyHasIP {
+		desc, ok, members = rj.Rate.AddMemberAt(key, sip, at)
+	} else {
+		desc, ok = rj.Rate.AddAt(key, at)
+	}
+	if ok {
+		rj.arrestIPCount.Incr()
+		logger.Infof("[discipline-%s][watch-%s] arrest(%s): %s %s", rj.id, line.WatchID, desc, sip, line.Text)
+		bad = NewBadLog(line, rj.id, ip, groups...)
+		for _, m := range members {
+			if m == sip {
+				continue
+			}
+			rj.arrestIPCount.Incr()
+			logger.Infof("[discipline-%s][watch-%s] arrest accomplice(%s): %s %s", rj.id, line.WatchID, key, m, line.Text)
+			bad.Accomplices = append(bad.Accomplices, net.ParseIP(m))
+		}
+		return bad, true
+	}
+	rj.watchIPCount.Incr()
+	logger.Infof("[discipline-%s][watch-%s] watch-on(%s): %s %s", rj.id, line.WatchID, desc, sip, line.Text)
+	return bad, false
+}
+
+type RegexDiscipline struct {
+	BaseDiscipline `yaml:",inline"`
+	Matches        *Matcher `yaml:"matches,omitempty"`
+	Ignores        *Ignores `yaml:"ignores,omitempty"`
+	When           *Expr    `yaml:"when,omitempty"`
+	RateJudge      `yaml:",inline"`
+}
+
 func NewRegexDiscipline(decode Decoder) (Discipliner, error) {
 	var rd RegexDiscipline
 	if err := decode(&rd); err != nil {
@@ -37,31 +191,35 @@ func NewRegexDiscipline(decode Decoder) (Discipliner, error) {
 		v, _ := rd.Matches.MarshalYAML()
 		return nil, fmt.Errorf("[discipline-%s] bad matches: %w, %s", id, err, v)
 	}
-	rd.tailLinesCount = RegisterNewCounter("discipline", id, "tail_lines")
-	rd.matchLineCount = RegisterNewCounter("discipline", id, "match_lines")
-	rd.badIPLineCount = RegisterNewCounter("discipline", id, "bad_ip")
-	rd.allowIPCount = RegisterNewCounter("discipline", id, "allow_ip")
-	rd.watchIPCount = RegisterNewCounter("discipline", id, "watch_ip")
-	rd.arrestIPCount = RegisterNewCounter("discipline", id, "arrest_ip")
+	if rd.Ignores != nil {
+		if err := rd.Ignores.Init(id, rd.Matches); err != nil {
+			return nil, fmt.Errorf("[discipline-%s] %w", id, err)
+		}
+	}
+	if rd.When != nil {
+		for _, name := range rd.When.Vars() {
+			if !rd.Matches.HasGroup(name) {
+				return nil, fmt.Errorf("[discipline-%s] group %q of when not found in matches", id, name)
+			}
+		}
+	}
+	if err := rd.RateJudge.CheckGroups(rd.Matches.HasGroup); err != nil {
+		return nil, fmt.Errorf("[discipline-%s] %w in matches", id, err)
+	}
+	rd.RateJudge.Init(id)
 	return &rd, nil
 }
 
-func (rd *RegexDiscipline) Close() error {
-	rd.Rate.Stop()
-	return nil
+func (rd *RegexDiscipline) RequiredLiterals() []string {
+	return rd.Matches.RequiredLiterals()
 }
 
-func (rd *RegexDiscipline) AllowIP(ip net.IP) bool {
-	for _, v := range rd.Allows {
-		if v.Contains(ip) {
-			return true
-		}
-	}
-	return false
+func (rd *RegexDiscipline) Skip(line Line) {
+	rd.Tail()
 }
 
 func (rd *RegexDiscipline) Judge(line Line, allow Allows, logger Logger) (bad BadLog, ok bool) {
-	rd.tailLinesCount.Incr()
+	rd.Tail()
 	if line.Text == "" || rd.Matches == nil {
 		ok = false
 		return
@@ -72,33 +230,19 @@ func (rd *RegexDiscipline) Judge(line Line, allow Allows, logger Logger) (bad Ba
 		ok = false
 		return
 	}
-	if rd.Ignores != nil && rd.Ignores.Test(groups[0].Value) {
-		logger.Debugf("[discipline-%s][watch-%s] regex ignore: length=%d", rd.ID, line.WatchID, len(line.Text))
-		ok = false
-		return
-	}
-	rd.matchLineCount.Incr()
-	ip := net.ParseIP(groups.Get("ip"))
-	if ip == nil {
-		logger.Debugf("[discipline-%s][watch-%s] no ip group: %s", rd.ID, line.WatchID, groups)
-		rd.badIPLineCount.Incr()
-		ok = false
-		return
-	}
-	if allow.Contains(ip) || rd.Allows.Contains(ip) {
-		rd.allowIPCount.Incr()
-		ok = false
-		return
+	if rd.Ignores != nil {
+		if rule, yes := rd.Ignores.Ignore(groups); yes {
+			logger.Debugf("[discipline-%s][watch-%s] regex ignore by %s: length=%d", rd.ID, line.WatchID, rule, len(line.Text))
+			ok = false
+			return
+		}
 	}
-	sip := ip.String()
-	desc, ok := rd.Rate.Add(sip)
-	if ok {
-		rd.arrestIPCount.Incr()
-		logger.Infof("[discipline-%s][watch-%s] arrest(%s): %s %s", rd.ID, line.WatchID, desc, sip, line.Text)
-		bad = NewBadLog(line, rd.ID, ip, groups...)
-		return bad, true
+	if rd.When != nil {
+		if yes, err := rd.When.Eval(groups); err != nil || !yes {
+			logger.Debugf("[discipline-%s][watch-%s] when not satisfied: %v", rd.ID, line.WatchID, err)
+			ok = false
+			return
+		}
 	}
-	rd.watchIPCount.Incr()
-	logger.Infof("[discipline-%s][watch-%s] watch-on(%s): %s %s", rd.ID, line.WatchID, desc, sip, line.Text)
-	return bad, false
+	return rd.RateJudge.Judge(line, groups, allow, logger)
 }
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Distinct counts distinct values of a group per key within a duration,
+// e.g. many usernames tried from one ip.
+// At most Min values are kept for one key, so memory is bounded.
+type Distinct struct {
+	Group  string        `yaml:"group"`
+	Min    int           `yaml:"min"`
+	Within time.Duration `yaml:"within"`
+
+	mu     sync.Mutex
+	wg     sync.WaitGroup
+	mp     map[string]map[string]time.Time
+	cancel func()
+	clock  eventClock
+}
+
+type distinctYAML struct {
+	Group  string        `yaml:"group"`
+	Min    int           `yaml:"min"`
+	Within time.Duration `yaml:"within"`
+}
+
+func (d *Distinct) UnmarshalYAML(b []byte) error {
+	var v distinctYAML
+	if err := YamlDecode(b, &v); err != nil {
+		return err
+	}
+	if v.Group == "" {
+		return fmt.Errorf("distinct group is empty")
+	}
+	if v.Min < 1 {
+		return fmt.Errorf("distinct min must be positive: %d", v.Min)
+	}
+	if v.Within < time.Millisecond {
+		return fmt.Errorf("bad distinct within: %s", v.Within)
+	}
+	d.Group = v.Group
+	d.Min = v.Min
+	d.Within = v.Within
+	return nil
+}
+
+func (d *Distinct) MarshalYAML() (any, error) {
+	return distinctYAML{Group: d.Group, Min: d.Min, Within: d.Within}, nil
+}
+
+func (d *Distinct) String() string {
+	return fmt.Sprintf("%d %s/%s", d.Min, d.Group, formatDuration(d.Within))
+}
+
+// AddAt records value of key seen at now, returns distinct values count
+// of key within the duration. Empty value is not recorded.
+func (d *Distinct) AddAt(key, value string, now time.Time) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.cancel == nil {
+		d.startBackground()
+	}
+	if d.mp == nil {
+		d.mp = map[string]map[string]time.Time{}
+	}
+	d.clock.observe(now)
+	values := d.mp[key]
+	if values == nil {
+		values = map[string]time.Time{}
+		d.mp[key] = values
+	}
+	since := now.Add(-d.Within)
+	for k, v := range values {
+		if !v.After(since) {
+			delete(values, k)
+		}
+	}
+	if value != "" {
+		if _, ok := values[value]; ok || len(values) < d.Min {
+			values[value] = now
+		}
+	}
+	if len(values) == 0 {
+		delete(d.mp, key)
+	}
+	return len(values)
+}
+
+// Reset forgets values of key.
+func (d *Distinct) Reset(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.mp, key)
+}
+
+func (d *Distinct) startBackground() {
+	ctx, cancel := context.WithCancel(context.Background())
+	d.cancel = cancel
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		tick := time.NewTicker(time.Second * 10)
+		defer tick.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick.C:
+				d.mu.Lock()
+				since := d.clock.now().Add(-d.Within)
+				for key, values := range d.mp {
+					for k, v := range values {
+						if !v.After(since) {
+							delete(values, k)
+						}
+					}
+					if len(values) == 0 {
+						delete(d.mp, key)
+					}
+				}
+				d.mu.Unlock()
+			}
+		}
+	}()
+}
+
+func (d *Distinct) Stop() {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	cancel := d.cancel
+	d.cancel = nil
+	d.mp = nil
+	d.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+	d.wg.Wait()
+}
@@ -12,6 +12,29 @@ type watchCallback struct {
 	d                 *Discipline
 	js                []*Jail
 	IPLocationSources IPLocationSources
+	e                 *Engine
+}
+
+func (w watchCallback) Skip(line Line) {
+	if d, ok := w.d.Action.(IndexedDiscipliner); ok {
+		d.Skip(line)
+	}
+}
+
+// newDispatch returns a match index of callbacks, a callback is a candidate of a line
+// if the line contains a required literal of its discipline.
+// Callbacks without literals are always candidates, nil means all callbacks are.
+func newDispatch(callbacks []watchCallback) *prefilter {
+	if len(callbacks) < 2 {
+		return nil
+	}
+	literals := make([][]string, len(callbacks))
+	for i, c := range callbacks {
+		if d, ok := c.d.Action.(IndexedDiscipliner); ok {
+			literals[i] = d.RequiredLiterals()
+		}
+	}
+	return newLiteralFilter(literals)
 }
 
 func (w watchCallback) Exec(line Line, allow Allows, logger Logger) {
@@ -19,9 +42,28 @@ func (w watchCallback) Exec(line Line, allow Allows, logger Logger) {
 	if !ok {
 		return
 	}
-	ip := bad.IP
-	bad.IPLocation = w.IPLocationSources.GetLocation(logger, ip)
-	logger.Debugf("[engine][discipline-%s][watch-%s] start arrest ip: %s %s %s", bad.DisciplineID, bad.WatchID, ip, bad.IPLocation, bad.Line)
+	accomplices := bad.Accomplices
+	bad.Accomplices = nil
+	w.escalate(bad, logger)
+	for _, ip := range accomplices {
+		b := bad
+		b.IP = ip
+		w.escalate(b, logger)
+	}
+}
+
+// escalate arrests the prefix of ip instead if aggregation of discipline is reached.
+func (w watchCallback) escalate(bad BadLog, logger Logger) {
+	if prefix := w.d.Aggregate.AddAt(bad.IP, bad.At()); prefix != nil {
+		logger.Infof("[engine][discipline-%s][watch-%s] escalate %s to prefix %s(%s)", bad.DisciplineID, bad.WatchID, bad.IP, prefix, w.d.Aggregate)
+		bad.Prefix = prefix
+	}
+	w.arrest(bad, logger)
+}
+
+func (w watchCallback) arrest(bad BadLog, logger Logger) {
+	bad.IPLocation = w.IPLocationSources.GetLocation(logger, bad.IP)
+	logger.Debugf("[engine][discipline-%s][watch-%s] start arrest ip: %s %s %s", bad.DisciplineID, bad.WatchID, bad.Target(), bad.IPLocation, bad.Line)
 	for _, j := range w.js {
 		if j.Background {
 			go runJail(bad, j, logger)
@@ -29,10 +71,27 @@ func (w watchCallback) Exec(line Line, allow Allows, logger Logger) {
 			runJail(bad, j, logger)
 		}
 	}
+	if _, ok := w.d.Action.(ArrestListener); ok {
+		return
+	}
+	for _, l := range w.e.listeners {
+		l.listen(bad, logger)
+	}
 }
 
-func runJail(bad BadLog, j *Jail, logger Logger) {
-	ip := bad.IP
+// listen judges an arrest of other discipline by listener discipline.
+func (w watchCallback) listen(bad BadLog, logger Logger) {
+	l := w.d.Action.(ArrestListener)
+	bad.Accomplices = nil
+	r, ok := l.JudgeArrest(bad, logger)
+	if !ok {
+		return
+	}
+	w.escalate(r, logger)
+}
+
+func runJail(bad BadLog, j *Jail, logger Logger) error {
+	ip := bad.Target()
 	logger.Debugf("[engine][discipline-%s][watch-%s][jail-%s] start arrest %s[%s] by line: %s", bad.DisciplineID, bad.WatchID, j.ID, ip, bad.IPLocation, bad.Line)
 	err := j.Action.Arrest(bad, logger)
 	if err != nil {
@@ -40,10 +99,13 @@ func runJail(bad BadLog, j *Jail, logger Logger) {
 	} else {
 		logger.Infof("[engine][discipline-%s][watch-%s][jail-%s] arrest success: %s[%s]", bad.DisciplineID, bad.WatchID, j.ID, ip, bad.IPLocation)
 	}
+	return err
 }
 
 type Engine struct {
 	watchList map[*Watch][]watchCallback
+	listeners []watchCallback
+	feeds     map[*Feed][]*Jail
 	cancels   Finisher
 	waits     Finisher
 	ctx       context.Context
@@ -54,6 +116,7 @@ func newEngine(logger Logger) *Engine {
 	ctx, cancel := context.WithCancel(context.Background())
 	e := &Engine{
 		watchList: make(map[*Watch][]watchCallback),
+		feeds:     make(map[*Feed][]*Jail),
 		ctx:       ctx,
 		logger:    logger,
 	}
@@ -77,12 +140,27 @@ func (e *Engine) StopAndWait() {
 }
 
 func (e *Engine) AddDiscipline(w *Watch, d *Discipline, js []*Jail, sources IPLocationSources) {
-	e.watchList[w] = append(e.watchList[w], watchCallback{d: d, js: js, IPLocationSources: sources})
+	e.watchList[w] = append(e.watchList[w], watchCallback{d: d, js: js, IPLocationSources: sources, e: e})
 	e.cancels.Push(func() {
 		d.Action.Close()
+		d.Aggregate.Stop()
 	})
 }
 
+// AddListener adds a discipline listening arrests of other disciplines.
+func (e *Engine) AddListener(d *Discipline, js []*Jail, sources IPLocationSources) {
+	e.listeners = append(e.listeners, watchCallback{d: d, js: js, IPLocationSources: sources, e: e})
+	e.cancels.Push(func() {
+		d.Action.Close()
+		d.Aggregate.Stop()
+	})
+}
+
+// AddFeed adds a feed arresting its networks by jails.
+func (e *Engine) AddFeed(f *Feed, js []*Jail) {
+	e.feeds[f] = js
+}
+
 func (e *Engine) StartStatServer(addr string, logger Logger) {
 	server := http.Server{
 		Addr: addr,
@@ -91,11 +169,14 @@ func (e *Engine) StartStatServer(addr string, logger Logger) {
 				http.NotFound(w, r)
 				return
 			}
-			if r.URL.Path != "/" {
+			switch r.URL.Path {
+			case "/":
+				OutputCounters(w)
+			case "/scores":
+				OutputScores(w)
+			default:
 				http.NotFound(w, r)
-				return
 			}
-			OutputCounters(w)
 		}),
 	}
 	var wg sync.WaitGroup
@@ -113,17 +194,50 @@ func (e *Engine) StartStatServer(addr string, logger Logger) {
 }
 
 func (e *Engine) Start(testing bool, allow Allows, logger Logger) error {
-	if len(e.watchList) == 0 {
+	if len(e.watchList) == 0 && len(e.feeds) == 0 {
 		return fmt.Errorf("nothing to do")
 	}
+	allow.Start(logger)
+	e.cancels.Push(allow.Stop)
+	e.startAllows(logger)
 	for w, callbacks := range e.watchList {
 		if err := e.startWatch(testing, w, callbacks, allow, logger); err != nil {
 			return err
 		}
 	}
+	for f, js := range e.feeds {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f.Run(e.ctx, js, allow, logger)
+		}()
+		e.waits.Push(wg.Wait)
+	}
 	return nil
 }
 
+// startAllows loads allows of disciplines before any line is judged,
+// they are stopped when disciplines close.
+func (e *Engine) startAllows(logger Logger) {
+	type allowsStarter interface {
+		StartAllows(logger Logger)
+	}
+	start := func(c watchCallback) {
+		if s, ok := c.d.Action.(allowsStarter); ok {
+			s.StartAllows(logger)
+		}
+	}
+	for _, callbacks := range e.watchList {
+		for _, c := range callbacks {
+			start(c)
+		}
+	}
+	for _, c := range e.listeners {
+		start(c)
+	}
+}
+
 func (e *Engine) startWatch(testing bool,
 	w *Watch, callbacks []watchCallback, allow Allows, log Logger) error {
 	var (
@@ -138,6 +252,10 @@ func (e *Engine) startWatch(testing bool,
 	if err != nil {
 		return err
 	}
+	if w.Multiline != nil {
+		ch = w.Multiline.Assemble(e.ctx, ch)
+	}
+	dispatch := newDispatch(callbacks)
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
@@ -155,9 +273,21 @@ func (e *Engine) startWatch(testing bool,
 					log.Debugf("[engine][discipline-%s] watch channel close", w.ID)
 					return
 				}
-				for _, c := range callbacks {
+				line.Replay = line.Replay || testing || w.Replay
+				var hits *[]bool
+				if dispatch != nil {
+					hits = dispatch.candidates(line.Text)
+				}
+				for i, c := range callbacks {
+					if hits != nil && !(*hits)[i] {
+						c.Skip(line)
+						continue
+					}
 					c.Exec(line, allow, log)
 				}
+				if hits != nil {
+					dispatch.release(hits)
+				}
 			}
 		}
 	}()
@@ -194,6 +324,10 @@ func Start(cfg *Config, logger Logger, test string, statListen string) (wait, st
 			}
 			jails = append(jails, cfg.Jails[idx])
 		}
+		if _, ok := d.Action.(ArrestListener); ok {
+			eg.AddListener(d, jails, cfg.IPLocationSources)
+			continue
+		}
 		for _, w := range d.Watches {
 			idx := slices.IndexFunc(cfg.Watches, func(e *Watch) bool {
 				return e.ID == w
@@ -205,9 +339,35 @@ func Start(cfg *Config, logger Logger, test string, statListen string) (wait, st
 			eg.AddDiscipline(cfg.Watches[idx], d, jails, cfg.IPLocationSources)
 		}
 	}
+	// feeds are not tested, they have no lines.
+	for _, f := range cfg.Feeds {
+		if testing {
+			continue
+		}
+		jails, err := findJails(cfg, f.Jails)
+		if err != nil {
+			eg.StopAndWait()
+			return nil, nil, err
+		}
+		eg.AddFeed(f, jails)
+	}
 	if err := eg.Start(testing, cfg.Allows, logger); err != nil {
 		eg.StopAndWait()
 		return nil, nil, err
 	}
 	return eg.Wait, eg.Stop, nil
 }
+
+func findJails(cfg *Config, ids []string) ([]*Jail, error) {
+	var jails []*Jail
+	for _, j := range ids {
+		idx := slices.IndexFunc(cfg.Jails, func(e *Jail) bool {
+			return e.ID == j
+		})
+		if idx < 0 {
+			return nil, fmt.Errorf("jail id not exist: %s", j)
+		}
+		jails = append(jails, cfg.Jails[idx])
+	}
+	return jails, nil
+}
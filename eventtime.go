@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event time layouts, other layouts are treated as go time layouts.
+const (
+	timeSyslog  = "syslog"
+	timeISO8601 = "iso8601"
+	timeNginx   = "nginx"
+	timeEpoch   = "epoch"
+)
+
+var (
+	syslogLayouts  = []string{time.Stamp, time.StampMicro}
+	iso8601Layouts = []string{
+		time.RFC3339Nano,
+		"2006-01-02T15:04:05.999999999Z0700",
+		"2006-01-02 15:04:05.999999999Z07:00",
+		"2006-01-02 15:04:05.999999999Z0700",
+		"2006-01-02T15:04:05.999999999",
+		"2006-01-02 15:04:05.999999999",
+	}
+	nginxLayouts = []string{"02/Jan/2006:15:04:05 -0700"}
+)
+
+// EventTime extracts time of a log event from a named group.
+type EventTime struct {
+	Group string `yaml:"group"`
+	// Layout is one of syslog, iso8601, nginx, epoch or a go time layout.
+	Layout string `yaml:"layout"`
+	// Timezone is used when the time has no zone, default is local.
+	Timezone string `yaml:"timezone,omitempty"`
+
+	loc *time.Location
+}
+
+func (e *EventTime) UnmarshalYAML(b []byte) error {
+	type alias EventTime
+	var v alias
+	if err := YamlDecode(b, &v); err != nil {
+		return err
+	}
+	if v.Group == "" {
+		v.Group = "time"
+	}
+	if v.Layout == "" {
+		v.Layout = timeISO8601
+	}
+	v.loc = time.Local
+	if v.Timezone != "" {
+		loc, err := time.LoadLocation(v.Timezone)
+		if err != nil {
+			return fmt.Errorf("bad timezone: %w", err)
+		}
+		v.loc = loc
+	}
+	*e = EventTime(v)
+	return nil
+}
+
+// Parse parses s by the layout, now is used to infer the year of syslog time.
+func (e *EventTime) Parse(s string, now time.Time) (time.Time, error) {
+	loc := e.loc
+	if loc == nil {
+		loc = time.Local
+	}
+	s = strings.TrimSpace(s)
+	switch e.Layout {
+	case timeSyslog:
+		// syslog time has no year, it is the latest one not after now.
+		t, err := parseTimeLayouts(syslogLayouts, strings.Join(strings.Fields(s), " "), loc)
+		if err != nil {
+			return t, err
+		}
+		t = t.AddDate(now.In(loc).Year()-t.Year(), 0, 0)
+		if t.After(now.Add(24 * time.Hour)) {
+			t = t.AddDate(-1, 0, 0)
+		}
+		return t, nil
+	case timeISO8601:
+		return parseTimeLayouts(iso8601Layouts, s, loc)
+	case timeNginx:
+		return parseTimeLayouts(nginxLayouts, s, loc)
+	case timeEpoch:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+			return time.Time{}, fmt.Errorf("bad epoch time: %q", s)
+		}
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(frac*1e9)), nil
+	default:
+		return time.ParseInLocation(e.Layout, s, loc)
+	}
+}
+
+func parseTimeLayouts(layouts []string, s string, loc *time.Location) (time.Time, error) {
+	for _, l := range layouts {
+		t, err := time.ParseInLocation(l, s, loc)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("bad time: %q", s)
+}
+
+// eventClock is the clock of a window fed by event times,
+// it advances from the latest event time by wall clock,
+// so that windows of replayed events are not swept at once.
+// It's not safe for concurrent use, guard it by the lock of the window.
+type eventClock struct {
+	// latest is the latest event time and seen is the wall clock when it's observed.
+	latest time.Time
+	seen   time.Time
+}
+
+func (c *eventClock) observe(at time.Time) {
+	if at.After(c.latest) {
+		c.latest = at
+		c.seen = time.Now()
+	}
+}
+
+func (c *eventClock) now() time.Time {
+	if c.latest.IsZero() {
+		return time.Now()
+	}
+	return c.latest.Add(time.Since(c.seen))
+}
@@ -0,0 +1,740 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/netip"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expr is a boolean expression over named groups, compiled when config is loaded.
+//
+//	status >= 400 and bytes < 100 and not starts_with(path, '/health')
+//
+// Operands are group names, numbers, quoted strings, true, false and lists like ['GET', 'POST'].
+// Operators are or(||), and(&&), not(!), in, not in, == != < <= > >=, + - * / %.
+// Group values are strings, they are compared as numbers when compared with a number
+// or when both sides look like numbers.
+// Functions:
+//
+//	len(s) lower(s) upper(s) trim(s) num(s) str(x)
+//	contains(s, sub) starts_with(s, prefix) ends_with(s, suffix)
+//	matches(s, 'regex') cidr(ip, '10.0.0.0/8', ...)
+//
+// The regex of matches and cidr arguments must be literals.
+type Expr struct {
+	src  string
+	root exprNode
+	vars []string
+}
+
+func CompileExpr(src string) (*Expr, error) {
+	tokens, err := lexExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	p := exprParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+	}
+	e := &Expr{src: src, root: root}
+	e.vars = p.vars
+	slices.Sort(e.vars)
+	e.vars = slices.Compact(e.vars)
+	return e, nil
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Vars returns group names referenced by expression.
+func (e *Expr) Vars() []string {
+	return e.vars
+}
+
+func (e *Expr) MarshalYAML() (any, error) {
+	return e.src, nil
+}
+
+func (e *Expr) UnmarshalYAML(b []byte) error {
+	var s string
+	if err := YamlDecode(b, &s); err != nil {
+		return err
+	}
+	v, err := CompileExpr(s)
+	if err != nil {
+		return fmt.Errorf("bad expression %q: %w", s, err)
+	}
+	*e = *v
+	return nil
+}
+
+// Eval evaluates expression with groups, the result must be a bool.
+func (e *Expr) Eval(groups KeyValueList) (bool, error) {
+	v, err := e.root.eval(groups)
+	if err != nil {
+		return false, err
+	}
+	return exprBool(v)
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokOp
+)
+
+type exprToken struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func lexExpr(s string) ([]exprToken, error) {
+	var tokens []exprToken
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '_' || unicode.IsLetter(rune(c)):
+			start := i
+			for i < len(s) && (s[i] == '_' || unicode.IsLetter(rune(s[i])) || unicode.IsDigit(rune(s[i]))) {
+				i++
+			}
+			tokens = append(tokens, exprToken{kind: tokIdent, text: s[start:i], pos: start})
+		case unicode.IsDigit(rune(c)) || (c == '.' && i+1 < len(s) && unicode.IsDigit(rune(s[i+1]))):
+			start := i
+			for i < len(s) && (unicode.IsDigit(rune(s[i])) || s[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, exprToken{kind: tokNumber, text: s[start:i], pos: start})
+		case c == '\'' || c == '"':
+			start := i
+			var bs strings.Builder
+			i++
+			for i < len(s) && s[i] != c {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				bs.WriteByte(s[i])
+				i++
+			}
+			if i >= len(s) {
+				return nil, fmt.Errorf("unterminated string at %d", start)
+			}
+			i++
+			tokens = append(tokens, exprToken{kind: tokString, text: bs.String(), pos: start})
+		default:
+			op := ""
+			for _, v := range []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "+", "-", "*", "/", "%", "(", ")", ",", "[", "]"} {
+				if strings.HasPrefix(s[i:], v) {
+					op = v
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %q at %d", c, i)
+			}
+			tokens = append(tokens, exprToken{kind: tokOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	tokens = append(tokens, exprToken{kind: tokEOF, text: "end of expression", pos: len(s)})
+	return tokens, nil
+}
+
+type exprParser struct {
+	tokens []exprToken
+	i      int
+	vars   []string
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.i]
+}
+
+func (p *exprParser) next() exprToken {
+	t := p.tokens[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+// accept consumes next token if it is one of ops or keywords.
+func (p *exprParser) accept(ops ...string) (string, bool) {
+	t := p.peek()
+	if t.kind != tokOp && t.kind != tokIdent {
+		return "", false
+	}
+	if slices.Contains(ops, t.text) {
+		p.i++
+		return t.text, true
+	}
+	return "", false
+}
+
+func (p *exprParser) expect(op string) error {
+	if _, ok := p.accept(op); !ok {
+		t := p.peek()
+		return fmt.Errorf("expect %q but got %q at %d", op, t.text, t.pos)
+	}
+	return nil
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("or", "||"); !ok {
+			return l, nil
+		}
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryNode{op: "or", l: l, r: r}
+	}
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	l, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("and", "&&"); !ok {
+			return l, nil
+		}
+		r, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryNode{op: "and", l: l, r: r}
+	}
+}
+
+func (p *exprParser) parseNot() (exprNode, error) {
+	if _, ok := p.accept("not", "!"); ok {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: "not", x: x}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *exprParser) parseCompare() (exprNode, error) {
+	l, err := p.parseAdd()
+	if err != nil {
+		return nil, err
+	}
+	if op, ok := p.accept("==", "!=", "<", "<=", ">", ">="); ok {
+		r, err := p.parseAdd()
+		if err != nil {
+			return nil, err
+		}
+		return &binaryNode{op: op, l: l, r: r}, nil
+	}
+	not := false
+	if p.peek().text == "not" && p.tokens[p.i+1].text == "in" {
+		p.i++
+		not = true
+	}
+	if _, ok := p.accept("in"); ok {
+		r, err := p.parseAdd()
+		if err != nil {
+			return nil, err
+		}
+		var n exprNode = &binaryNode{op: "in", l: l, r: r}
+		if not {
+			n = &unaryNode{op: "not", x: n}
+		}
+		return n, nil
+	}
+	return l, nil
+}
+
+func (p *exprParser) parseAdd() (exprNode, error) {
+	l, err := p.parseMul()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("+", "-")
+		if !ok {
+			return l, nil
+		}
+		r, err := p.parseMul()
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryNode{op: op, l: l, r: r}
+	}
+}
+
+func (p *exprParser) parseMul() (exprNode, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("*", "/", "%")
+		if !ok {
+			return l, nil
+		}
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryNode{op: op, l: l, r: r}
+	}
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if _, ok := p.accept("-"); ok {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: "-", x: x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number %q at %d", t.text, t.pos)
+		}
+		return &literalNode{v: f}, nil
+	case tokString:
+		return &literalNode{v: t.text}, nil
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return &literalNode{v: true}, nil
+		case "false":
+			return &literalNode{v: false}, nil
+		case "and", "or", "not", "in":
+			return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+		}
+		if _, ok := p.accept("("); ok {
+			return p.parseCall(t)
+		}
+		p.vars = append(p.vars, t.text)
+		return &varNode{name: t.text}, nil
+	case tokOp:
+		switch t.text {
+		case "(":
+			x, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expect(")")
+		case "[":
+			var list listNode
+			if _, ok := p.accept("]"); ok {
+				return &list, nil
+			}
+			for {
+				x, err := p.parseOr()
+				if err != nil {
+					return nil, err
+				}
+				list.items = append(list.items, x)
+				if _, ok := p.accept("]"); ok {
+					return &list, nil
+				}
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+}
+
+func (p *exprParser) parseCall(name exprToken) (exprNode, error) {
+	fn, ok := exprFuncs[name.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q at %d", name.text, name.pos)
+	}
+	call := &callNode{name: name.text, fn: fn.fn}
+	if _, ok := p.accept(")"); !ok {
+		for {
+			x, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			call.args = append(call.args, x)
+			if _, ok := p.accept(")"); ok {
+				break
+			}
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(call.args) < fn.min || (fn.max >= 0 && len(call.args) > fn.max) {
+		return nil, fmt.Errorf("bad argument count of %s at %d", name.text, name.pos)
+	}
+	switch name.text {
+	case "matches":
+		lit, ok := call.args[1].(*literalNode)
+		s, isString := lit.value().(string)
+		if !ok || !isString {
+			return nil, fmt.Errorf("regex of matches must be a string at %d", name.pos)
+		}
+		s, err := ExpandPatterns(s)
+		if err != nil {
+			return nil, fmt.Errorf("bad regex of matches at %d: %w", name.pos, err)
+		}
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return nil, fmt.Errorf("bad regex of matches at %d: %w", name.pos, err)
+		}
+		call.re = re
+	case "cidr":
+		for _, arg := range call.args[1:] {
+			lit, ok := arg.(*literalNode)
+			s, isString := lit.value().(string)
+			if !ok || !isString {
+				return nil, fmt.Errorf("network of cidr must be a string at %d", name.pos)
+			}
+			p, err := ParsePrefix(s)
+			if err != nil {
+				return nil, fmt.Errorf("bad network of cidr at %d: %w", name.pos, err)
+			}
+			if call.nets == nil {
+				call.nets = NewIPSet()
+			}
+			call.nets.Add(p)
+		}
+	}
+	return call, nil
+}
+
+type exprNode interface {
+	eval(groups KeyValueList) (any, error)
+}
+
+type literalNode struct {
+	v any
+}
+
+func (n *literalNode) value() any {
+	if n == nil {
+		return nil
+	}
+	return n.v
+}
+
+func (n *literalNode) eval(KeyValueList) (any, error) {
+	return n.v, nil
+}
+
+type varNode struct {
+	name string
+}
+
+func (n *varNode) eval(groups KeyValueList) (any, error) {
+	return groups.Get(n.name), nil
+}
+
+type listNode struct {
+	items []exprNode
+}
+
+func (n *listNode) eval(groups KeyValueList) (any, error) {
+	var r []any
+	for _, item := range n.items {
+		v, err := item.eval(groups)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, v)
+	}
+	return r, nil
+}
+
+type unaryNode struct {
+	op string
+	x  exprNode
+}
+
+func (n *unaryNode) eval(groups KeyValueList) (any, error) {
+	v, err := n.x.eval(groups)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "not" {
+		b, err := exprBool(v)
+		return !b, err
+	}
+	f, err := exprNumber(v)
+	return -f, err
+}
+
+type binaryNode struct {
+	op   string
+	l, r exprNode
+}
+
+func (n *binaryNode) eval(groups KeyValueList) (any, error) {
+	l, err := n.l.eval(groups)
+	if err != nil {
+		return nil, err
+	}
+	// short circuit
+	switch n.op {
+	case "and", "or":
+		b, err := exprBool(l)
+		if err != nil {
+			return nil, err
+		}
+		if b == (n.op == "or") {
+			return b, nil
+		}
+		r, err := n.r.eval(groups)
+		if err != nil {
+			return nil, err
+		}
+		return exprBool(r)
+	}
+	r, err := n.r.eval(groups)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "in":
+		list, ok := r.([]any)
+		if !ok {
+			return nil, fmt.Errorf("right side of in must be a list")
+		}
+		for _, v := range list {
+			if c, err := exprCompare(l, v); err == nil && c == 0 {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "==", "!=":
+		c, err := exprCompare(l, r)
+		if err != nil {
+			return nil, err
+		}
+		return (c == 0) == (n.op == "=="), nil
+	case "<", "<=", ">", ">=":
+		c, err := exprCompare(l, r)
+		if err != nil {
+			return nil, err
+		}
+		switch n.op {
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		default:
+			return c >= 0, nil
+		}
+	case "+":
+		ls, lok := l.(string)
+		rs, rok := r.(string)
+		if lok && rok && !(exprIsNumber(ls) && exprIsNumber(rs)) {
+			return ls + rs, nil
+		}
+	}
+	a, err := exprNumber(l)
+	if err != nil {
+		return nil, err
+	}
+	b, err := exprNumber(r)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/":
+		if b == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return a / b, nil
+	case "%":
+		if b == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return math.Mod(a, b), nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", n.op)
+}
+
+type callNode struct {
+	name string
+	fn   func(c *callNode, args []any) (any, error)
+	args []exprNode
+	re   *regexp.Regexp
+	nets *IPSet
+}
+
+func (n *callNode) eval(groups KeyValueList) (any, error) {
+	args := make([]any, 0, len(n.args))
+	for _, a := range n.args {
+		v, err := a.eval(groups)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+	return n.fn(n, args)
+}
+
+type exprFunc struct {
+	min, max int
+	fn       func(c *callNode, args []any) (any, error)
+}
+
+func stringFunc(fn func(s string) any) exprFunc {
+	return exprFunc{min: 1, max: 1, fn: func(_ *callNode, args []any) (any, error) {
+		return fn(exprString(args[0])), nil
+	}}
+}
+
+func stringsFunc(fn func(s, t string) bool) exprFunc {
+	return exprFunc{min: 2, max: 2, fn: func(_ *callNode, args []any) (any, error) {
+		return fn(exprString(args[0]), exprString(args[1])), nil
+	}}
+}
+
+var exprFuncs map[string]exprFunc
+
+func init() {
+	exprFuncs = map[string]exprFunc{
+		"len":         stringFunc(func(s string) any { return float64(len(s)) }),
+		"lower":       stringFunc(func(s string) any { return strings.ToLower(s) }),
+		"upper":       stringFunc(func(s string) any { return strings.ToUpper(s) }),
+		"trim":        stringFunc(func(s string) any { return strings.TrimSpace(s) }),
+		"str":         stringFunc(func(s string) any { return s }),
+		"contains":    stringsFunc(strings.Contains),
+		"starts_with": stringsFunc(strings.HasPrefix),
+		"ends_with":   stringsFunc(strings.HasSuffix),
+		"num": {min: 1, max: 1, fn: func(_ *callNode, args []any) (any, error) {
+			return exprNumber(args[0])
+		}},
+		"matches": {min: 2, max: 2, fn: func(c *callNode, args []any) (any, error) {
+			return c.re.MatchString(exprString(args[0])), nil
+		}},
+		"cidr": {min: 2, max: -1, fn: func(c *callNode, args []any) (any, error) {
+			addr, err := netip.ParseAddr(exprString(args[0]))
+			if err != nil {
+				return false, nil
+			}
+			return c.nets.Contains(addr), nil
+		}},
+	}
+}
+
+func exprString(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func exprIsNumber(s string) bool {
+	_, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	return err == nil
+}
+
+func exprNumber(v any) (float64, error) {
+	switch v := v.(type) {
+	case float64:
+		return v, nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return 0, fmt.Errorf("not a number: %q", v)
+		}
+		return f, nil
+	default:
+		return 0, fmt.Errorf("not a number: %v", v)
+	}
+}
+
+func exprBool(v any) (bool, error) {
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("not a bool: %v", v)
+	}
+	return b, nil
+}
+
+// exprCompare compares as numbers if either side is a number or both sides look like numbers.
+func exprCompare(l, r any) (int, error) {
+	_, lnum := l.(float64)
+	_, rnum := r.(float64)
+	ls, lstr := l.(string)
+	rs, rstr := r.(string)
+	if lnum || rnum || (lstr && rstr && exprIsNumber(ls) && exprIsNumber(rs)) {
+		a, err := exprNumber(l)
+		if err != nil {
+			return 0, err
+		}
+		b, err := exprNumber(r)
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case a < b:
+			return -1, nil
+		case a > b:
+			return 1, nil
+		}
+		return 0, nil
+	}
+	if lstr && rstr {
+		return strings.Compare(ls, rs), nil
+	}
+	lb, lok := l.(bool)
+	rb, rok := r.(bool)
+	if lok && rok {
+		if lb == rb {
+			return 0, nil
+		}
+		return 1, nil
+	}
+	return 0, fmt.Errorf("can not compare %v with %v", l, r)
+}
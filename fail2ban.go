@@ -0,0 +1,591 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// iniFile is sections of a fail2ban config file, keys are lower case.
+type iniFile map[string]map[string]string
+
+func parseINI(r io.Reader) (iniFile, error) {
+	ini := iniFile{}
+	var (
+		section string
+		key     string
+		lineNo  int
+	)
+	scan := bufio.NewScanner(r)
+	for scan.Scan() {
+		lineNo++
+		line := strings.TrimRight(scan.Text(), " \t\r")
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			key = ""
+			continue
+		case trimmed[0] == '#' || trimmed[0] == ';':
+			continue
+		case line[0] == ' ' || line[0] == '\t':
+			// continuation of previous value
+			if key == "" {
+				return nil, fmt.Errorf("line %d: unexpected continuation", lineNo)
+			}
+			v := ini[section][key]
+			if v != "" {
+				v += "\n"
+			}
+			ini[section][key] = v + trimmed
+			continue
+		case trimmed[0] == '[' && trimmed[len(trimmed)-1] == ']':
+			section = strings.TrimSpace(trimmed[1 : len(trimmed)-1])
+			if ini[section] == nil {
+				ini[section] = map[string]string{}
+			}
+			key = ""
+			continue
+		}
+		i := strings.IndexAny(line, "=:")
+		if i <= 0 {
+			return nil, fmt.Errorf("line %d: bad line: %s", lineNo, line)
+		}
+		if section == "" {
+			return nil, fmt.Errorf("line %d: value outside section", lineNo)
+		}
+		key = strings.ToLower(strings.TrimSpace(line[:i]))
+		key = strings.TrimSuffix(key, "+") // fail2ban 'key +=' is not supported, treat it as '='
+		key = strings.TrimSpace(key)
+		ini[section][key] = strings.TrimSpace(line[i+1:])
+	}
+	return ini, scan.Err()
+}
+
+// merge sets values of src into dst.
+func (ini iniFile) merge(src iniFile) {
+	for section, values := range src {
+		if ini[section] == nil {
+			ini[section] = map[string]string{}
+		}
+		for k, v := range values {
+			ini[section][k] = v
+		}
+	}
+}
+
+var fail2banInterpolateRe = regexp.MustCompile(`%\(([^)]+)\)s`)
+
+// get returns interpolated value of key in section, [DEFAULT] is looked up as fallback.
+func (ini iniFile) get(section, key string) (string, bool) {
+	v, ok := ini.raw(section, key)
+	if !ok {
+		return "", false
+	}
+	s, err := ini.interpolate(section, v, 0)
+	if err != nil {
+		return "", false
+	}
+	return s, true
+}
+
+func (ini iniFile) raw(section, key string) (string, bool) {
+	if v, ok := ini[section][key]; ok {
+		return v, true
+	}
+	if v, ok := ini["Init"][key]; ok && section == "Definition" {
+		return v, true
+	}
+	v, ok := ini["DEFAULT"][key]
+	return v, ok
+}
+
+func (ini iniFile) interpolate(section, s string, depth int) (string, error) {
+	if depth > 20 {
+		return "", errors.New("interpolation too deep")
+	}
+	var err error
+	r := fail2banInterpolateRe.ReplaceAllStringFunc(s, func(ref string) string {
+		name := strings.ToLower(ref[2 : len(ref)-2])
+		if name == "__name__" {
+			return section
+		}
+		v, ok := ini.raw(section, name)
+		if !ok {
+			if err == nil {
+				err = fmt.Errorf("unknown variable %s", ref)
+			}
+			return ref
+		}
+		v, err1 := ini.interpolate(section, v, depth+1)
+		if err1 != nil && err == nil {
+			err = err1
+		}
+		return v
+	})
+	return strings.ReplaceAll(r, "%%", "%"), err
+}
+
+// loadFail2banFile reads file with its .local override and [INCLUDES] before and after.
+func loadFail2banFile(file string, seen []string) (iniFile, error) {
+	if slices.Contains(seen, file) {
+		return nil, fmt.Errorf("include cycle: %s", strings.Join(append(seen, file), " -> "))
+	}
+	seen = append(seen, file)
+	var own iniFile
+	for _, f := range []string{file, strings.TrimSuffix(file, filepath.Ext(file)) + ".local"} {
+		b, err := os.ReadFile(f)
+		if errors.Is(err, fs.ErrNotExist) && f != file {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		ini, err := parseINI(strings.NewReader(string(b)))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f, err)
+		}
+		if own == nil {
+			own = ini
+		} else {
+			own.merge(ini)
+		}
+	}
+	include := func(key string) (iniFile, error) {
+		r := iniFile{}
+		for _, name := range strings.Fields(own["INCLUDES"][key]) {
+			ini, err := loadFail2banFile(filepath.Join(filepath.Dir(file), name), seen)
+			if err != nil {
+				return nil, err
+			}
+			r.merge(ini)
+		}
+		return r, nil
+	}
+	r, err := include("before")
+	if err != nil {
+		return nil, err
+	}
+	r.merge(own)
+	after, err := include("after")
+	if err != nil {
+		return nil, err
+	}
+	r.merge(after)
+	return r, nil
+}
+
+var (
+	fail2banHostTagRe  = regexp.MustCompile(`<(HOST|ADDR|IP4|IP6|CIDR|SUBNET)>`)
+	fail2banFieldRe    = regexp.MustCompile(`<(/?)F-([A-Za-z0-9_]+)>`)
+	fail2banBackrefRe  = regexp.MustCompile(`\(\?P=\w+\)|\\[1-9]`)
+	fail2banLookRe     = regexp.MustCompile(`\(\?<?[=!]`)
+	fail2banUnknownTag = regexp.MustCompile(`<[A-Z][A-Z0-9_-]*>`)
+)
+
+// convertFail2banRegex converts an interpolated fail2ban regex to go2jail regex.
+func convertFail2banRegex(s string) (string, error) {
+	if strings.Contains(s, "<SKIPLINES>") {
+		return "", errors.New("multi-line <SKIPLINES> is not supported")
+	}
+	if strings.Contains(s, "<DNS>") {
+		return "", errors.New("<DNS> is not supported, only ip could be arrested")
+	}
+	if fail2banLookRe.MatchString(s) {
+		return "", errors.New("lookahead and lookbehind are not supported")
+	}
+	if fail2banBackrefRe.MatchString(s) {
+		return "", errors.New("backreferences are not supported")
+	}
+	if m := fail2banInterpolateRe.FindString(s); m != "" {
+		return "", fmt.Errorf("unknown variable %s", m)
+	}
+	if len(fail2banHostTagRe.FindAllString(s, 2)) > 1 {
+		return "", errors.New("more than one host tag is not supported")
+	}
+	var err error
+	s = strings.ReplaceAll(s, "{^LN-BEG}", "")
+	s = fail2banHostTagRe.ReplaceAllString(s, "%(ip)")
+	s = fail2banFieldRe.ReplaceAllStringFunc(s, func(tag string) string {
+		m := fail2banFieldRe.FindStringSubmatch(tag)
+		name := strings.ToLower(m[2])
+		switch name {
+		case "nofail", "mlfforget", "mlfgained", "mlfid":
+			if err == nil {
+				err = fmt.Errorf("<F-%s> is not supported", m[2])
+			}
+		}
+		if m[1] == "/" {
+			return ")"
+		}
+		return "(?P<" + name + ">"
+	})
+	if err != nil {
+		return "", err
+	}
+	if m := fail2banUnknownTag.FindString(s); m != "" {
+		return "", fmt.Errorf("unknown tag %s", m)
+	}
+	// python \Z is go \z
+	s = strings.ReplaceAll(s, `\Z`, `\z`)
+	expanded, err := ExpandPatterns(s)
+	if err != nil {
+		return "", err
+	}
+	if _, err := regexp.Compile(expanded); err != nil {
+		return "", err
+	}
+	return s, nil
+}
+
+type fail2banDiscipline struct {
+	ID      string   `yaml:"id"`
+	Type    string   `yaml:"type"`
+	Watches []string `yaml:"watches"`
+	Jails   []string `yaml:"jails"`
+	Rate    string   `yaml:"rate,omitempty"`
+	Matches []string `yaml:"matches"`
+	Ignores []string `yaml:"ignores,omitempty"`
+	Allows  []string `yaml:"allows,omitempty"`
+}
+
+type fail2banWatch struct {
+	ID                    string   `yaml:"id"`
+	Type                  string   `yaml:"type"`
+	Files                 []string `yaml:"files,omitempty"`
+	SkipWhenFileNotExists bool     `yaml:"skip_when_file_not_exists,omitempty"`
+	RestartPolicy         string   `yaml:"restart_policy,omitempty"`
+	Run                   string   `yaml:"run,omitempty"`
+}
+
+type fail2banJail struct {
+	ID      string `yaml:"id"`
+	Type    string `yaml:"type"`
+	Rule    string `yaml:"rule"`
+	Table   string `yaml:"table"`
+	IPv4Set string `yaml:"ipv4_set"`
+	IPv6Set string `yaml:"ipv6_set"`
+}
+
+type fail2banConfig struct {
+	Jails       []*fail2banJail       `yaml:"jails,omitempty"`
+	Watches     []*fail2banWatch      `yaml:"watches,omitempty"`
+	Disciplines []*fail2banDiscipline `yaml:"disciplines"`
+	Allows      []string              `yaml:"allows,omitempty"`
+}
+
+// fail2banImporter converts fail2ban config directory,
+// problems are collected instead of failing the whole import.
+type fail2banImporter struct {
+	dir      string
+	filters  map[string]*fail2banDiscipline
+	problems []string
+}
+
+func (im *fail2banImporter) problemf(format string, args ...any) {
+	im.problems = append(im.problems, fmt.Sprintf(format, args...))
+}
+
+// importFail2ban converts a fail2ban directory (e.g. /etc/fail2ban) or a filter.d directory.
+func importFail2ban(dir string) (*fail2banConfig, []string, error) {
+	im := fail2banImporter{dir: dir, filters: map[string]*fail2banDiscipline{}}
+	filterDir := filepath.Join(dir, "filter.d")
+	if _, err := os.Stat(filterDir); err != nil {
+		filterDir = dir
+	}
+	files, err := filepath.Glob(filepath.Join(filterDir, "*.conf"))
+	if err != nil {
+		return nil, nil, err
+	}
+	locals, _ := filepath.Glob(filepath.Join(filterDir, "*.local"))
+	for _, f := range locals {
+		if conf := strings.TrimSuffix(f, ".local") + ".conf"; !slices.Contains(files, conf) {
+			files = append(files, f)
+		}
+	}
+	slices.Sort(files)
+	var cfg fail2banConfig
+	for _, f := range files {
+		name := strings.TrimSuffix(filepath.Base(f), filepath.Ext(f))
+		if d := im.convertFilter(name, f); d != nil {
+			im.filters[name] = d
+		}
+	}
+	used := map[string]bool{}
+	if err := im.convertJails(&cfg, used); err != nil {
+		return nil, nil, err
+	}
+	for _, f := range files {
+		name := strings.TrimSuffix(filepath.Base(f), filepath.Ext(f))
+		if d := im.filters[name]; d != nil && !used[name] {
+			cfg.Disciplines = append(cfg.Disciplines, d)
+		}
+	}
+	if len(cfg.Disciplines) == 0 {
+		return nil, im.problems, fmt.Errorf("no filter converted in %s", dir)
+	}
+	return &cfg, im.problems, nil
+}
+
+func (im *fail2banImporter) convertFilter(name, file string) *fail2banDiscipline {
+	ini, err := loadFail2banFile(file, nil)
+	if err != nil {
+		im.problemf("filter %s: %v", name, err)
+		return nil
+	}
+	if _, ok := ini["Definition"]; !ok {
+		// included files like common.conf
+		return nil
+	}
+	convert := func(key string) []string {
+		raw, ok := ini.raw("Definition", key)
+		if !ok {
+			return nil
+		}
+		var r []string
+		for i, line := range strings.Split(raw, "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" {
+				continue
+			}
+			s, err := ini.interpolate("Definition", line, 0)
+			if err == nil {
+				s, err = convertFail2banRegex(s)
+			}
+			if err != nil {
+				im.problemf("filter %s: %s %d: %v: %s", name, key, i+1, err, line)
+				continue
+			}
+			r = append(r, s)
+		}
+		return r
+	}
+	d := &fail2banDiscipline{
+		ID:      name,
+		Type:    "regex",
+		Watches: []string{},
+		Jails:   []string{},
+		Matches: convert("failregex"),
+		Ignores: convert("ignoreregex"),
+	}
+	if len(d.Matches) == 0 {
+		im.problemf("filter %s: no failregex converted", name)
+		return nil
+	}
+	for i, m := range d.Matches {
+		if !strings.Contains(m, "%(ip)") {
+			im.problemf("filter %s: failregex has no host: %s", name, m)
+			return nil
+		}
+		if len(d.Ignores) > 0 {
+			// ignores test the whole match, fail2ban tests ignoreregex on the whole line.
+			d.Matches[i] = "^.*?(?:" + m + ").*$"
+		}
+	}
+	return d
+}
+
+func (im *fail2banImporter) convertJails(cfg *fail2banConfig, used map[string]bool) error {
+	var files []string
+	for _, pattern := range []string{"jail.conf", "jail.d/*.conf", "jail.local", "jail.d/*.local"} {
+		m, err := filepath.Glob(filepath.Join(im.dir, pattern))
+		if err != nil {
+			return err
+		}
+		slices.Sort(m)
+		files = append(files, m...)
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	ini := iniFile{}
+	for _, f := range files {
+		v, err := loadFail2banFile(f, nil)
+		if err != nil {
+			im.problemf("%v", err)
+			continue
+		}
+		ini.merge(v)
+	}
+	cfg.Allows = im.convertIgnoreIP("DEFAULT", ini["DEFAULT"]["ignoreip"])
+	var sections []string
+	for section := range ini {
+		switch section {
+		case "DEFAULT", "INCLUDES", "Definition", "Init":
+			continue
+		}
+		sections = append(sections, section)
+	}
+	slices.Sort(sections)
+	for _, section := range sections {
+		enabled, _ := ini.get(section, "enabled")
+		if b, _ := strconv.ParseBool(enabled); !b {
+			continue
+		}
+		filter, ok := ini.get(section, "filter")
+		if !ok {
+			filter = section
+		}
+		if i := strings.Index(filter, "["); i >= 0 {
+			im.problemf("jail %s: filter options ignored: %s", section, filter[i:])
+			filter = strings.TrimSpace(filter[:i])
+		}
+		base := im.filters[filter]
+		if base == nil {
+			im.problemf("jail %s: filter %s not converted", section, filter)
+			continue
+		}
+		used[filter] = true
+		watch, err := im.convertWatch(ini, section)
+		if err != nil {
+			im.problemf("jail %s: %v", section, err)
+			continue
+		}
+		if len(cfg.Jails) == 0 {
+			cfg.Jails = append(cfg.Jails, &fail2banJail{
+				ID:      "nft",
+				Type:    "nftset",
+				Rule:    "inet",
+				Table:   "filter",
+				IPv4Set: "ipv4_block_set",
+				IPv6Set: "ipv6_block_set",
+			})
+		}
+		if action, ok := ini.get(section, "action"); ok {
+			im.problemf("jail %s: action is mapped to jail nft: %s", section, strings.ReplaceAll(action, "\n", " "))
+		}
+		cfg.Watches = append(cfg.Watches, watch)
+		d := *base
+		d.ID = section
+		d.Watches = []string{watch.ID}
+		d.Jails = []string{"nft"}
+		d.Rate = im.convertRate(ini, section)
+		if v, ok := ini[section]["ignoreip"]; ok {
+			d.Allows = im.convertIgnoreIP(section, v)
+		}
+		cfg.Disciplines = append(cfg.Disciplines, &d)
+	}
+	return nil
+}
+
+func (im *fail2banImporter) convertWatch(ini iniFile, section string) (*fail2banWatch, error) {
+	backend, _ := ini.get(section, "backend")
+	if backend == "systemd" {
+		match, _ := ini.get(section, "journalmatch")
+		return &fail2banWatch{
+			ID:            section,
+			Type:          "shell",
+			RestartPolicy: "always",
+			Run:           strings.TrimSpace("journalctl -f -n 0 " + strings.Join(strings.Fields(match), " ")),
+		}, nil
+	}
+	logpath, ok := ini.get(section, "logpath")
+	if !ok || strings.TrimSpace(logpath) == "" {
+		return nil, errors.New("no logpath")
+	}
+	var files []string
+	for _, f := range strings.Fields(logpath) {
+		if f == "missingok" {
+			continue
+		}
+		if strings.ContainsAny(f, "*?[") {
+			m, _ := filepath.Glob(f)
+			if len(m) == 0 {
+				im.problemf("jail %s: logpath glob matches nothing on this host: %s", section, f)
+			}
+			files = append(files, m...)
+			continue
+		}
+		files = append(files, f)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no logpath file: %s", logpath)
+	}
+	return &fail2banWatch{
+		ID:                    section,
+		Type:                  "file",
+		Files:                 files,
+		SkipWhenFileNotExists: true,
+	}, nil
+}
+
+func (im *fail2banImporter) convertRate(ini iniFile, section string) string {
+	maxretry := 5
+	if v, ok := ini.get(section, "maxretry"); ok {
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || n < 1 {
+			im.problemf("jail %s: bad maxretry %s, use %d", section, v, maxretry)
+		} else {
+			maxretry = n
+		}
+	}
+	findtime := 10 * time.Minute
+	if v, ok := ini.get(section, "findtime"); ok {
+		d, err := parseFail2banTime(v)
+		if err != nil {
+			im.problemf("jail %s: bad findtime %s, use %s", section, v, findtime)
+		} else {
+			findtime = d
+		}
+	}
+	return fmt.Sprintf("%d/%s", maxretry, formatDuration(findtime))
+}
+
+func (im *fail2banImporter) convertIgnoreIP(section, s string) []string {
+	var r []string
+	for _, v := range strings.FieldsFunc(s, func(r rune) bool { return r == ' ' || r == ',' || r == '\n' }) {
+		n, err := ParsePrefix(v)
+		if err != nil {
+			im.problemf("%s: ignoreip %s is not an ip or cidr, skipped", section, v)
+			continue
+		}
+		r = append(r, n.String())
+	}
+	return r
+}
+
+// parseFail2banTime parses seconds or abbreviated time like 10m, 1h, 1d, 1w.
+func parseFail2banTime(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if n, err := strconv.Atoi(s); err == nil {
+		return time.Duration(n) * time.Second, nil
+	}
+	units := map[string]time.Duration{
+		"s": time.Second, "sec": time.Second, "m": time.Minute, "min": time.Minute,
+		"h": time.Hour, "hour": time.Hour, "d": 24 * time.Hour, "day": 24 * time.Hour,
+		"w": 7 * 24 * time.Hour, "week": 7 * 24 * time.Hour,
+	}
+	var total time.Duration
+	for s != "" {
+		i := 0
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		for i < len(s) && s[i] == ' ' {
+			i++
+		}
+		j := i
+		for j < len(s) && s[j] >= 'a' && s[j] <= 'z' {
+			j++
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(s[:i]))
+		unit, ok := units[strings.TrimSuffix(s[i:j], "s")]
+		if s[i:j] == "s" {
+			unit, ok = time.Second, true
+		}
+		if err != nil || !ok {
+			return 0, fmt.Errorf("bad time: %s", s)
+		}
+		total += time.Duration(n) * unit
+		s = strings.TrimSpace(s[j:])
+	}
+	return total, nil
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Feed formats, auto accepts all formats of which the first field is the network.
+const (
+	feedAuto      = "auto"
+	feedList      = "list"
+	feedDrop      = "drop"
+	feedNetset    = "netset"
+	feedAbuseIPDB = "abuseipdb"
+)
+
+var feedFormats = []string{feedAuto, feedList, feedDrop, feedNetset, feedAbuseIPDB}
+
+// feedWorkers bounds concurrent calls of jails which could not arrest in batch.
+const feedWorkers = 8
+
+// Feed is a deny list of networks loaded from file:<path> or url:<http url>,
+// networks are arrested by jails of the feed before they even hit a log,
+// and released when they disappear from the feed.
+// Networks are kept in State file if it's set, so that networks removed
+// while the daemon is down are released on the next start.
+type Feed struct {
+	ID      string        `yaml:"id"`
+	Source  string        `yaml:"source"`
+	Format  string        `yaml:"format,omitempty"`
+	Refresh time.Duration `yaml:"refresh,omitempty"`
+	Jails   Strings       `yaml:"jails"`
+	State   string        `yaml:"state,omitempty"`
+
+	kind      string
+	target    string
+	entries   *IPSet
+	addCnt    *Counter
+	removeCnt *Counter
+	failCnt   *Counter
+}
+
+func (f *Feed) UnmarshalYAML(b []byte) error {
+	type alias Feed
+	var v alias
+	if err := YamlDecode(b, &v); err != nil {
+		return err
+	}
+	if v.ID == "" {
+		return errors.New("feed id is empty")
+	}
+	kind, target, ok := cutAllowSource(v.Source)
+	if !ok || kind == allowDNS {
+		return fmt.Errorf("[feed-%s] source must be file:<path> or url:<http url>: %s", v.ID, v.Source)
+	}
+	// a tampered deny list never allows anything, plain http is accepted.
+	if err := checkAllowSource(kind, target, true); err != nil {
+		return fmt.Errorf("[feed-%s] bad source %s: %w", v.ID, v.Source, err)
+	}
+	if v.Format == "" {
+		v.Format = feedAuto
+	}
+	if !slices.Contains(feedFormats, v.Format) {
+		return fmt.Errorf("[feed-%s] unknown format: %s", v.ID, v.Format)
+	}
+	if v.Refresh == 0 {
+		v.Refresh = time.Hour
+	}
+	if v.Refresh < time.Second {
+		return fmt.Errorf("[feed-%s] bad refresh: %s", v.ID, v.Refresh)
+	}
+	*f = Feed(v)
+	f.kind = kind
+	f.target = target
+	f.addCnt = RegisterNewCounter("feed", f.ID, "add")
+	f.removeCnt = RegisterNewCounter("feed", f.ID, "remove")
+	f.failCnt = RegisterNewCounter("feed", f.ID, "fail")
+	return nil
+}
+
+// DisciplineID is the discipline id of arrests of the feed.
+func (f *Feed) DisciplineID() string {
+	return "feed:" + f.ID
+}
+
+// Run refreshes the feed until ctx is done.
+func (f *Feed) Run(ctx context.Context, jails []*Jail, allow Allows, logger Logger) {
+	tick := time.NewTicker(f.Refresh)
+	defer tick.Stop()
+	for {
+		f.refresh(ctx, jails, allow, logger)
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// refresh arrests networks added to the feed and releases networks removed,
+// the feed is kept when it fails to load.
+// All networks are arrested on the first refresh since jails may lose them while the daemon is down.
+// Networks failed to arrest or release are retried on the next refresh.
+func (f *Feed) refresh(ctx context.Context, jails []*Jail, allow Allows, logger Logger) {
+	nets, err := f.load(ctx)
+	if err != nil {
+		f.failCnt.Incr()
+		logger.Errorf("[feed-%s] load %s fail: %v", f.ID, f.Source, err)
+		return
+	}
+	entries := NewIPSet()
+	for _, p := range nets {
+		if allow.Overlaps(p) {
+			logger.Infof("[feed-%s] skip network overlapping allows: %s", f.ID, p)
+			continue
+		}
+		entries.Add(p)
+	}
+	var added, removed []netip.Prefix
+	for _, p := range entries.Prefixes() {
+		if !f.entries.Has(p) {
+			added = append(added, p)
+		}
+	}
+	last := f.entries
+	if last == nil {
+		last = f.readState(logger)
+	}
+	for _, p := range last.Prefixes() {
+		if !entries.Has(p) {
+			removed = append(removed, p)
+		}
+	}
+	f.addCnt.Add(int64(len(added)))
+	f.removeCnt.Add(int64(len(removed)))
+	addFailed := make([]bool, len(added))
+	removeFailed := make([]bool, len(removed))
+	for _, j := range jails {
+		for i, failed := range f.arrest(ctx, j, f.badLogs(added), logger) {
+			addFailed[i] = addFailed[i] || failed
+		}
+		for i, failed := range f.release(ctx, j, f.badLogs(removed), logger) {
+			removeFailed[i] = removeFailed[i] || failed
+		}
+	}
+	var nfail int
+	for i, p := range added {
+		if addFailed[i] {
+			entries.Remove(p)
+			nfail++
+		}
+	}
+	for i, p := range removed {
+		if removeFailed[i] {
+			entries.Add(p)
+			nfail++
+		}
+	}
+	f.entries = entries
+	f.writeState(logger)
+	logger.Infof("[feed-%s] refreshed %d networks, added %d, removed %d, failed %d", f.ID, entries.Len(), len(added), len(removed), nfail)
+}
+
+// arrest reports whether each of bads fails to arrest.
+func (f *Feed) arrest(ctx context.Context, j *Jail, bads []BadLog, logger Logger) []bool {
+	failed := make([]bool, len(bads))
+	if len(bads) == 0 {
+		return failed
+	}
+	if b, ok := j.Action.(BatchJailer); ok {
+		if err := b.ArrestBatch(bads, logger); err != nil {
+			logger.Errorf("[feed-%s][jail-%s] arrest %d networks fail: %v", f.ID, j.ID, len(bads), err)
+			for i := range failed {
+				failed[i] = true
+			}
+		}
+		return failed
+	}
+	for i := range failed {
+		failed[i] = true
+	}
+	eachBadLog(ctx, bads, func(i int, bad BadLog) {
+		failed[i] = runJail(bad, j, logger) != nil
+	})
+	return failed
+}
+
+// release reports whether each of bads fails to release,
+// jails could not release never fail.
+func (f *Feed) release(ctx context.Context, j *Jail, bads []BadLog, logger Logger) []bool {
+	failed := make([]bool, len(bads))
+	if len(bads) == 0 {
+		return failed
+	}
+	if b, ok := j.Action.(BatchJailer); ok {
+		if err := b.ReleaseBatch(bads, logger); err != nil {
+			logger.Errorf("[feed-%s][jail-%s] release %d networks fail: %v", f.ID, j.ID, len(bads), err)
+			for i := range failed {
+				failed[i] = true
+			}
+		}
+		return failed
+	}
+	r, ok := j.Action.(Releaser)
+	if !ok {
+		return failed
+	}
+	for i := range failed {
+		failed[i] = true
+	}
+	eachBadLog(ctx, bads, func(i int, bad BadLog) {
+		err := r.Release(bad, logger)
+		if err != nil {
+			logger.Errorf("[feed-%s][jail-%s] release %s fail: %v", f.ID, j.ID, bad.Target(), err)
+		}
+		failed[i] = err != nil
+	})
+	return failed
+}
+
+// eachBadLog calls fn with index of bads by at most feedWorkers goroutines,
+// it stops early when ctx is done.
+func eachBadLog(ctx context.Context, bads []BadLog, fn func(int, BadLog)) {
+	ch := make(chan int)
+	var wg sync.WaitGroup
+	for range min(feedWorkers, len(bads)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range ch {
+				fn(i, bads[i])
+			}
+		}()
+	}
+	defer func() {
+		close(ch)
+		wg.Wait()
+	}()
+	for i := range bads {
+		select {
+		case <-ctx.Done():
+			return
+		case ch <- i:
+		}
+	}
+}
+
+// readState returns networks of the last run, empty if there is no state.
+func (f *Feed) readState(logger Logger) *IPSet {
+	set := NewIPSet()
+	if f.State == "" {
+		return set
+	}
+	b, err := os.ReadFile(f.State)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Errorf("[feed-%s] read state %s fail: %v", f.ID, f.State, err)
+		}
+		return set
+	}
+	for _, p := range parseFeedLines(b, "#") {
+		set.Add(p)
+	}
+	return set
+}
+
+func (f *Feed) writeState(logger Logger) {
+	if f.State == "" {
+		return
+	}
+	var bs bytes.Buffer
+	fmt.Fprintf(&bs, "# networks of feed %s\n", f.ID)
+	for _, p := range f.entries.Prefixes() {
+		bs.WriteString(p.String())
+		bs.WriteByte('\n')
+	}
+	tmp := f.State + ".tmp"
+	err := os.WriteFile(tmp, bs.Bytes(), 0o644)
+	if err == nil {
+		err = os.Rename(tmp, f.State)
+	}
+	if err != nil {
+		logger.Errorf("[feed-%s] write state %s fail: %v", f.ID, f.State, err)
+	}
+}
+
+func (f *Feed) badLogs(nets []netip.Prefix) []BadLog {
+	bads := make([]BadLog, len(nets))
+	for i, p := range nets {
+		bads[i] = f.badLog(p)
+	}
+	return bads
+}
+
+func (f *Feed) badLog(p netip.Prefix) BadLog {
+	n := IPNetFromPrefix(p)
+	bad := BadLog{
+		Line:         fmt.Sprintf("feed %s: %s", f.ID, p),
+		DisciplineID: f.DisciplineID(),
+		IP:           n.IP,
+		Time:         time.Now(),
+	}
+	if !p.IsSingleIP() {
+		bad.Prefix = &n
+	}
+	return bad
+}
+
+func (f *Feed) load(ctx context.Context) ([]netip.Prefix, error) {
+	var (
+		b   []byte
+		err error
+	)
+	if f.kind == allowFile {
+		b, err = os.ReadFile(f.target)
+	} else {
+		b, err = fetchURL(ctx, f.target)
+	}
+	if err != nil {
+		return nil, err
+	}
+	nets, err := ParseFeed(f.Format, b)
+	if err == nil && len(nets) == 0 && hasFeedContent(b) {
+		// e.g. an html error page or a file being rewritten.
+		return nil, errors.New("no network found in non-empty content")
+	}
+	return nets, err
+}
+
+// hasFeedContent reports whether b has any line other than blanks and comments.
+func hasFeedContent(b []byte) bool {
+	for line := range strings.Lines(string(b)) {
+		line = strings.TrimSpace(line)
+		if line != "" && line[0] != '#' && line[0] != ';' {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseFeed parses networks of a feed:
+// list and netset (FireHOL) are one entry per line with # comments,
+// drop (Spamhaus DROP) uses ; comments, abuseipdb is csv with column ipAddress.
+func ParseFeed(format string, b []byte) ([]netip.Prefix, error) {
+	switch format {
+	case feedAuto:
+		return ParseIPList(b), nil
+	case feedList, feedNetset:
+		return parseFeedLines(b, "#"), nil
+	case feedDrop:
+		return parseFeedLines(b, ";"), nil
+	case feedAbuseIPDB:
+		return parseAbuseIPDB(b)
+	default:
+		return nil, fmt.Errorf("unknown format: %s", format)
+	}
+}
+
+func parseFeedLines(b []byte, comment string) []netip.Prefix {
+	var nets []netip.Prefix
+	scan := bufio.NewScanner(bytes.NewReader(b))
+	for scan.Scan() {
+		line, _, _ := strings.Cut(scan.Text(), comment)
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if p, err := ParsePrefix(fields[0]); err == nil {
+			nets = append(nets, p)
+		}
+	}
+	return nets
+}
+
+func parseAbuseIPDB(b []byte) ([]netip.Prefix, error) {
+	r := csv.NewReader(bytes.NewReader(b))
+	r.FieldsPerRecord = -1
+	var (
+		nets   []netip.Prefix
+		column int
+	)
+	for i := 0; ; i++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			return nets, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			if idx := slices.Index(record, "ipAddress"); idx >= 0 {
+				column = idx
+				continue
+			}
+		}
+		if column >= len(record) {
+			continue
+		}
+		if p, err := ParsePrefix(record[column]); err == nil {
+			nets = append(nets, p)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Ignores excludes matched lines from judging.
+// It is either regexes tested on the whole match:
+//
+//	ignores: ['rhost=127\.0\.0\.1']
+//
+// or rules of named groups, a rule is regexes or an exact list:
+//
+//	ignores:
+//	  user: ['^deploy$']
+//	  path:
+//	    in: ['/healthz', '/ping']
+//	    regex: ['^/static/']
+type Ignores struct {
+	whole *Matcher
+	rules []*GroupIgnore
+
+	wholeCounter *Counter
+}
+
+// GroupIgnore ignores lines whose named group matches any regex or equals any of In.
+type GroupIgnore struct {
+	Regex *Matcher `yaml:"regex,omitempty"`
+	In    []string `yaml:"in,omitempty"`
+
+	group   string
+	counter *Counter
+}
+
+type groupIgnoreYAML GroupIgnore
+
+func (g *GroupIgnore) UnmarshalYAML(b []byte) error {
+	var m Matcher
+	if err := YamlDecode(b, &m); err == nil {
+		g.Regex = &m
+		return nil
+	}
+	var v groupIgnoreYAML
+	if err := YamlDecode(b, &v); err != nil {
+		return err
+	}
+	if v.Regex == nil && len(v.In) == 0 {
+		return fmt.Errorf("ignore rule has neither regex nor in")
+	}
+	*g = GroupIgnore(v)
+	return nil
+}
+
+func (g *GroupIgnore) Test(s string) bool {
+	if slices.Contains(g.In, s) {
+		return true
+	}
+	return g.Regex != nil && g.Regex.Test(s)
+}
+
+func (ig *Ignores) UnmarshalYAML(b []byte) error {
+	var m Matcher
+	if err := YamlDecode(b, &m); err == nil {
+		ig.whole = &m
+		return nil
+	}
+	var v map[string]*GroupIgnore
+	if err := YamlDecode(b, &v); err != nil {
+		return err
+	}
+	for group, rule := range v {
+		if rule == nil {
+			return fmt.Errorf("ignore rule of %s is empty", group)
+		}
+		rule.group = group
+		ig.rules = append(ig.rules, rule)
+	}
+	slices.SortFunc(ig.rules, func(a, b *GroupIgnore) int {
+		return strings.Compare(a.group, b.group)
+	})
+	return nil
+}
+
+func (ig *Ignores) MarshalYAML() (any, error) {
+	if ig.whole != nil {
+		return ig.whole.MarshalYAML()
+	}
+	v := map[string]*GroupIgnore{}
+	for _, rule := range ig.rules {
+		v[rule.group] = rule
+	}
+	return v, nil
+}
+
+// Init registers counters of discipline and checks groups of rules exist in any of matchers.
+func (ig *Ignores) Init(id string, matchers ...*Matcher) error {
+	if ig.whole != nil {
+		ig.wholeCounter = RegisterNewCounter("discipline", id, "ignore")
+		return nil
+	}
+	for _, rule := range ig.rules {
+		if !slices.ContainsFunc(matchers, func(m *Matcher) bool { return m.HasGroup(rule.group) }) {
+			return fmt.Errorf("group %q of ignores not found in matches", rule.group)
+		}
+		rule.counter = RegisterNewCounter("discipline", id, "ignore_"+rule.group)
+	}
+	return nil
+}
+
+// Ignore returns the rule name ignores groups.
+func (ig *Ignores) Ignore(groups KeyValueList) (string, bool) {
+	if ig.whole != nil {
+		if ig.whole.Test(groups[0].Value) {
+			ig.wholeCounter.Incr()
+			return "match", true
+		}
+		return "", false
+	}
+	for _, rule := range ig.rules {
+		if rule.Test(groups.Get(rule.group)) {
+			rule.counter.Incr()
+			return rule.group, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	RegisterWatcher("http", NewHTTPWatch)
+}
+
+const defaultIngestBodySize = 1024 * 1024
+
+// HTTPWatch receives lines pushed by http POST requests.
+// Body is newline-delimited text, or a JSON array of strings
+// when content type is application/json.
+type HTTPWatch struct {
+	BaseWatch   `yaml:",inline"`
+	Listen      string   `yaml:"listen"`
+	Path        string   `yaml:"path"`
+	Token       string   `yaml:"token"`
+	TokenFile   string   `yaml:"token_file"`
+	MaxBodySize int64    `yaml:"max_body_size"`
+	Rate        *Limiter `yaml:"rate,omitempty"`
+
+	realToken string
+	server    *http.Server
+	ch        *Chan[Line]
+	done      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+	// sendMu is held by handlers sending lines, Close holds it to close ch.
+	sendMu sync.RWMutex
+
+	linesCounter    *Counter
+	requestsCounter *Counter
+	rejectCounter   *Counter
+}
+
+func NewHTTPWatch(decode Decoder) (Watcher, error) {
+	var h HTTPWatch
+	if err := decode(&h); err != nil {
+		return nil, err
+	}
+	if _, _, err := net.SplitHostPort(h.Listen); err != nil {
+		return nil, fmt.Errorf("[watch-%s] bad listen: %w, %s", h.ID, err, h.Listen)
+	}
+	if h.Path == "" {
+		h.Path = "/"
+	}
+	if !strings.HasPrefix(h.Path, "/") {
+		return nil, fmt.Errorf("[watch-%s] path must start with /: %s", h.ID, h.Path)
+	}
+	if h.TokenFile != "" {
+		b, err := os.ReadFile(h.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		h.realToken = strings.TrimSpace(string(b))
+	} else {
+		h.realToken = h.Token
+	}
+	if h.MaxBodySize <= 0 {
+		h.MaxBodySize = defaultIngestBodySize
+	}
+	h.ch = NewChan[Line](0)
+	h.done = make(chan struct{})
+	h.linesCounter = RegisterNewCounter("watch", h.ID, "lines")
+	h.requestsCounter = RegisterNewCounter("watch", h.ID, "requests")
+	h.rejectCounter = RegisterNewCounter("watch", h.ID, "reject")
+	return &h, nil
+}
+
+func (hw *HTTPWatch) Watch(logger Logger) (<-chan Line, error) {
+	return hw.watch(logger)
+}
+
+func (hw *HTTPWatch) Test(logger Logger) (<-chan Line, error) {
+	return hw.watch(logger)
+}
+
+func (hw *HTTPWatch) watch(logger Logger) (<-chan Line, error) {
+	logger.Debugf("[watch-%s] watch starting", hw.ID)
+	ln, err := net.Listen("tcp", hw.Listen)
+	if err != nil {
+		hw.ch.Close()
+		return nil, fmt.Errorf("[watch-%s] listen fail: %w", hw.ID, err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(hw.Path, hw.handler(logger))
+	hw.server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: time.Second * 10,
+	}
+	hw.wg.Add(1)
+	go func() {
+		defer hw.wg.Done()
+		err := hw.server.Serve(ln)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Errorf("[watch-%s] http serve fail: %v", hw.ID, err)
+		}
+	}()
+	logger.Infof("[watch-%s] listen on %s%s", hw.ID, ln.Addr(), hw.Path)
+	return hw.ch.Reader(), nil
+}
+
+func (hw *HTTPWatch) handler(logger Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hw.requestsCounter.Incr()
+		if r.URL.Path != hw.Path {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			client = r.RemoteAddr
+		}
+		// rate is checked first, so unauthorized floods are limited too.
+		if !hw.Rate.Allow(client) {
+			hw.rejectCounter.Incr()
+			logger.Debugf("[watch-%s] client %s reach rate %s", hw.ID, client, hw.Rate)
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		if !hw.authorized(r) {
+			hw.rejectCounter.Incr()
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		lines, err := hw.readLines(w, r)
+		if err != nil {
+			hw.rejectCounter.Incr()
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := hw.send(client, lines, logger); err != nil {
+			http.Error(w, "watch closed", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// send gives up once Close begins, ch is never closed while sending.
+func (hw *HTTPWatch) send(client string, lines []string, logger Logger) error {
+	hw.sendMu.RLock()
+	defer hw.sendMu.RUnlock()
+	for _, text := range lines {
+		logger.Debugf("[watch-%s] get line from %s: '%s'", hw.ID, client, text)
+		if err := hw.ch.SendDone(hw.done, NewLine(hw.ID, text)); err != nil {
+			return err
+		}
+		hw.linesCounter.Incr()
+	}
+	return nil
+}
+
+func (hw *HTTPWatch) authorized(r *http.Request) bool {
+	if hw.realToken == "" {
+		return true
+	}
+	auth := r.Header.Get("Authorization")
+	token, ok := strings.CutPrefix(auth, "Bearer ")
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(hw.realToken)) == 1
+}
+
+func (hw *HTTPWatch) readLines(w http.ResponseWriter, r *http.Request) ([]string, error) {
+	body := http.MaxBytesReader(w, r.Body, hw.MaxBodySize)
+	defer body.Close()
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		var lines []string
+		if err := json.NewDecoder(body).Decode(&lines); err != nil {
+			return nil, fmt.Errorf("bad json lines: %w", err)
+		}
+		return lines, nil
+	}
+	var lines []string
+	scan := bufio.NewScanner(body)
+	scan.Buffer(nil, int(hw.MaxBodySize))
+	for scan.Scan() {
+		text := strings.TrimSuffix(scan.Text(), "\r")
+		if text == "" {
+			continue
+		}
+		lines = append(lines, text)
+	}
+	return lines, scan.Err()
+}
+
+func (hw *HTTPWatch) Close() error {
+	hw.closeOnce.Do(func() {
+		// stop handlers sending and the server first, lines channel is closed after.
+		close(hw.done)
+		if hw.server != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if err := hw.server.Shutdown(ctx); err != nil {
+				hw.server.Close()
+			}
+		}
+		// handlers still running see done closed and never send again.
+		hw.sendMu.Lock()
+		hw.ch.Close()
+		hw.sendMu.Unlock()
+	})
+	hw.Rate.Stop()
+	hw.wg.Wait()
+	return nil
+}
@@ -240,6 +240,12 @@ func visitJSON(object any, path ...string) string {
 	switch v := object.(type) {
 	case string:
 		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
 	default:
 		return ""
 	}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+)
+
+// IPSet is a set of networks stored in binary prefix tries of IPv4 and IPv6.
+// IPv4-mapped IPv6 addresses are treated as IPv4.
+// It's not safe for concurrent writes, build a new set and swap it instead.
+type IPSet struct {
+	v4, v6 *ipSetNode
+	n      int
+}
+
+type ipSetNode struct {
+	child  [2]*ipSetNode
+	prefix netip.Prefix
+	set    bool
+}
+
+// NewIPSet returns a set of prefixes.
+func NewIPSet(prefixes ...netip.Prefix) *IPSet {
+	s := &IPSet{}
+	for _, p := range prefixes {
+		s.Add(p)
+	}
+	return s
+}
+
+// ParsePrefix parses a cidr or an ip, an ip is a prefix of full length.
+func ParsePrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("bad ip: %s", s)
+		}
+		addr = addr.Unmap().WithZone("")
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		return p, err
+	}
+	return normalizePrefix(p), nil
+}
+
+// normalizePrefix unmaps IPv4-mapped IPv6 prefixes of at least 96 bits,
+// shorter ones cover more than IPv4 and are kept as IPv6 prefixes.
+func normalizePrefix(p netip.Prefix) netip.Prefix {
+	addr, bits := p.Addr(), p.Bits()
+	if addr.Is4In6() && bits >= 96 {
+		addr, bits = addr.Unmap(), bits-96
+	}
+	return netip.PrefixFrom(addr, bits).Masked()
+}
+
+// AddrFromIP converts ip, IPv4-mapped IPv6 addresses are unmapped.
+func AddrFromIP(ip net.IP) (netip.Addr, bool) {
+	addr, ok := netip.AddrFromSlice(ip)
+	return addr.Unmap(), ok
+}
+
+// IPNetFromPrefix converts p to a net.IPNet.
+func IPNetFromPrefix(p netip.Prefix) net.IPNet {
+	return net.IPNet{
+		IP:   net.IP(p.Addr().AsSlice()),
+		Mask: net.CIDRMask(p.Bits(), p.Addr().BitLen()),
+	}
+}
+
+func (s *IPSet) root(addr netip.Addr, create bool) **ipSetNode {
+	r := &s.v6
+	if addr.Is4() {
+		r = &s.v4
+	}
+	if *r == nil && create {
+		*r = &ipSetNode{}
+	}
+	return r
+}
+
+func addrBit(b []byte, i int) byte {
+	return b[i/8] >> (7 - i%8) & 1
+}
+
+// Add adds p to the set, it reports whether p is new.
+func (s *IPSet) Add(p netip.Prefix) bool {
+	if !p.IsValid() {
+		return false
+	}
+	p = normalizePrefix(p)
+	node := *s.root(p.Addr(), true)
+	b := p.Addr().AsSlice()
+	for i := 0; i < p.Bits(); i++ {
+		bit := addrBit(b, i)
+		if node.child[bit] == nil {
+			node.child[bit] = &ipSetNode{}
+		}
+		node = node.child[bit]
+	}
+	if node.set {
+		return false
+	}
+	node.set = true
+	node.prefix = p
+	s.n++
+	return true
+}
+
+// Remove removes exactly p from the set, it reports whether p was in the set.
+func (s *IPSet) Remove(p netip.Prefix) bool {
+	if s == nil || !p.IsValid() {
+		return false
+	}
+	p = normalizePrefix(p)
+	r := s.root(p.Addr(), false)
+	if *r == nil {
+		return false
+	}
+	b := p.Addr().AsSlice()
+	path := []**ipSetNode{r}
+	node := *r
+	for i := 0; i < p.Bits() && node != nil; i++ {
+		next := &node.child[addrBit(b, i)]
+		path = append(path, next)
+		node = *next
+	}
+	if node == nil || !node.set {
+		return false
+	}
+	node.set = false
+	node.prefix = netip.Prefix{}
+	s.n--
+	// prune empty nodes from the leaf.
+	for i := len(path) - 1; i >= 0; i-- {
+		n := *path[i]
+		if n.set || n.child[0] != nil || n.child[1] != nil {
+			break
+		}
+		*path[i] = nil
+	}
+	return true
+}
+
+// Lookup returns the longest prefix containing addr.
+func (s *IPSet) Lookup(addr netip.Addr) (netip.Prefix, bool) {
+	if s == nil || !addr.IsValid() {
+		return netip.Prefix{}, false
+	}
+	addr = addr.Unmap()
+	var (
+		found netip.Prefix
+		ok    bool
+	)
+	node := *s.root(addr, false)
+	b := addr.AsSlice()
+	for i := 0; node != nil; i++ {
+		if node.set {
+			found, ok = node.prefix, true
+		}
+		if i >= len(b)*8 {
+			break
+		}
+		node = node.child[addrBit(b, i)]
+	}
+	return found, ok
+}
+
+// Contains reports whether any prefix of the set contains addr.
+func (s *IPSet) Contains(addr netip.Addr) bool {
+	if s == nil || !addr.IsValid() {
+		return false
+	}
+	addr = addr.Unmap()
+	node := *s.root(addr, false)
+	b := addr.AsSlice()
+	for i := 0; node != nil; i++ {
+		if node.set {
+			return true
+		}
+		if i >= len(b)*8 {
+			break
+		}
+		node = node.child[addrBit(b, i)]
+	}
+	return false
+}
+
+// ContainsIP likes Contains but accepts a net.IP.
+func (s *IPSet) ContainsIP(ip net.IP) bool {
+	addr, ok := AddrFromIP(ip)
+	return ok && s.Contains(addr)
+}
+
+// Has reports whether exactly p is in the set.
+func (s *IPSet) Has(p netip.Prefix) bool {
+	if s == nil || !p.IsValid() {
+		return false
+	}
+	p = normalizePrefix(p)
+	node := *s.root(p.Addr(), false)
+	b := p.Addr().AsSlice()
+	for i := 0; i < p.Bits() && node != nil; i++ {
+		node = node.child[addrBit(b, i)]
+	}
+	return node != nil && node.set
+}
+
+// Overlaps reports whether any prefix of the set contains or is contained by p.
+func (s *IPSet) Overlaps(p netip.Prefix) bool {
+	if s == nil || !p.IsValid() {
+		return false
+	}
+	p = normalizePrefix(p)
+	node := *s.root(p.Addr(), false)
+	b := p.Addr().AsSlice()
+	for i := 0; node != nil; i++ {
+		if node.set {
+			return true
+		}
+		if i >= p.Bits() {
+			// nodes are pruned, a node under p leads to a prefix of the set.
+			return true
+		}
+		node = node.child[addrBit(b, i)]
+	}
+	return false
+}
+
+// Merge adds all prefixes of o to the set.
+func (s *IPSet) Merge(o *IPSet) {
+	for _, p := range o.Prefixes() {
+		s.Add(p)
+	}
+}
+
+// Len returns the number of prefixes.
+func (s *IPSet) Len() int {
+	if s == nil {
+		return 0
+	}
+	return s.n
+}
+
+// Prefixes returns prefixes in order, IPv4 first.
+func (s *IPSet) Prefixes() []netip.Prefix {
+	if s == nil {
+		return nil
+	}
+	r := make([]netip.Prefix, 0, s.n)
+	var walk func(n *ipSetNode)
+	walk = func(n *ipSetNode) {
+		if n == nil {
+			return
+		}
+		if n.set {
+			r = append(r, n.prefix)
+		}
+		walk(n.child[0])
+		walk(n.child[1])
+	}
+	walk(s.v4)
+	walk(s.v6)
+	return r
+}
+
+func (s *IPSet) String() string {
+	var bs strings.Builder
+	for i, p := range s.Prefixes() {
+		if i > 0 {
+			bs.WriteByte(',')
+		}
+		bs.WriteString(p.String())
+	}
+	return bs.String()
+}
+
+func (s *IPSet) MarshalYAML() (any, error) {
+	ss := []string{}
+	for _, p := range s.Prefixes() {
+		ss = append(ss, p.String())
+	}
+	return ss, nil
+}
+
+func (s *IPSet) UnmarshalYAML(b []byte) error {
+	var ss []string
+	if err := yaml.Unmarshal(b, &ss); err != nil {
+		return err
+	}
+	for _, v := range ss {
+		p, err := ParsePrefix(v)
+		if err != nil {
+			return fmt.Errorf("bad ipcidr: %s, %w", v, err)
+		}
+		s.Add(p)
+	}
+	return nil
+}
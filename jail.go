@@ -16,6 +16,7 @@ import (
 	"net/textproto"
 	"os"
 	"os/exec"
+	"slices"
 	"strings"
 	"time"
 )
@@ -62,31 +63,82 @@ func NewNftJail(decode Decoder) (Jailer, error) {
 }
 
 func (nj *NftJail) Arrest(bad BadLog, log Logger) error {
+	set, elem := nj.element(bad)
+	return nj.run("add", set, []string{elem})
+}
+
+// Release deletes ip or prefix of bad from the set.
+func (nj *NftJail) Release(bad BadLog, log Logger) error {
+	set, elem := nj.element(bad)
+	return nj.run("delete", set, []string{elem})
+}
+
+// maxNftBatch bounds elements of one nft command.
+const maxNftBatch = 512
+
+func (nj *NftJail) ArrestBatch(bads []BadLog, log Logger) error {
+	return nj.runBatch("add", bads)
+}
+
+func (nj *NftJail) ReleaseBatch(bads []BadLog, log Logger) error {
+	return nj.runBatch("delete", bads)
+}
+
+// runBatch runs one command per set of at most maxNftBatch elements.
+func (nj *NftJail) runBatch(op string, bads []BadLog) error {
 	var (
-		s   string
-		set string
-		ip  = bad.IP
+		sets  []string
+		elems = map[string][]string{}
+		errs  []error
 	)
+	for _, bad := range bads {
+		set, elem := nj.element(bad)
+		if _, ok := elems[set]; !ok {
+			sets = append(sets, set)
+		}
+		elems[set] = append(elems[set], elem)
+	}
+	for _, set := range sets {
+		for chunk := range slices.Chunk(elems[set], maxNftBatch) {
+			if err := nj.run(op, set, chunk); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (nj *NftJail) element(bad BadLog) (set, elem string) {
+	ip := bad.IP
 	if ip.To4() != nil {
-		s = ip.To4().String()
+		elem = ip.To4().String()
 		set = nj.IPv4Set
 	} else {
-		s = ip.To16().String()
+		elem = ip.To16().String()
 		set = nj.IPv6Set
 	}
+	if bad.Prefix != nil {
+		// prefix elements require sets with flags interval.
+		elem = bad.Prefix.String()
+	}
+	return set, elem
+}
+
+// run counts success or fail for each element.
+func (nj *NftJail) run(op string, set string, elems []string) error {
 	var program []string
 	if nj.Sudo {
 		program = []string{"sudo"}
 	}
 	program = append(program,
 		nj.NftExecutable,
-		"add",
+		op,
 		"element",
 		nj.Rule,
 		nj.Table,
 		set,
 		"{",
-		s,
+		strings.Join(elems, ", "),
 		"}",
 	)
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
@@ -98,9 +150,9 @@ func (nj *NftJail) Arrest(bad BadLog, log Logger) error {
 	err := cmd.Run()
 	if err != nil {
 		err = fmt.Errorf("%w, args=%s, output=%s", err, program, buf.String())
-		nj.jailFailCounter.Incr()
+		nj.jailFailCounter.Add(int64(len(elems)))
 	} else {
-		nj.jailSuccessCounter.Incr()
+		nj.jailSuccessCounter.Add(int64(len(elems)))
 	}
 	return err
 }
@@ -136,7 +188,7 @@ type: echo`))
 }
 
 func (ej *EchoJail) Arrest(bad BadLog, log Logger) error {
-	fmt.Fprintln(Stdout, bad.IP.String(), bad.Line)
+	fmt.Fprintln(Stdout, bad.Target(), bad.Line)
 	ej.jailSuccessCounter.Incr()
 	return nil
 }
@@ -162,7 +214,7 @@ func NewLogJail(decode Decoder) (Jailer, error) {
 }
 
 func (ej *LogJail) Arrest(bad BadLog, log Logger) error {
-	log.Infof("[jail-%s] arrest ip %s, groups=%s", ej.ID, bad.IP, bad.Extend.String())
+	log.Infof("[jail-%s] arrest ip %s, groups=%s", ej.ID, bad.Target(), bad.Extend.String())
 	ej.jailSuccessCounter.Incr()
 	return nil
 }
@@ -172,8 +224,10 @@ func (ej *LogJail) Close() error {
 }
 
 type ShellJail struct {
-	BaseJail         `yaml:",inline"`
-	Run              string `yaml:"run"`
+	BaseJail `yaml:",inline"`
+	Run      string `yaml:"run"`
+	// ReleaseRun runs when an arrest is released, e.g. an entry removed from a feed.
+	ReleaseRun       string `yaml:"release,omitempty"`
 	YAMLScriptOption `yaml:",inline"`
 
 	jailSuccessCounter *Counter `yaml:"-"`
@@ -198,7 +252,7 @@ func (sj *ShellJail) Arrest(bad BadLog, log Logger) error {
 		YAMLScriptOption: sj.YAMLScriptOption,
 		Env:              bad.AsEnv(),
 	}
-	out, err := RunScript(sj.Run, &opt, bad.IP.String(), bad.Line)
+	out, err := RunScript(sj.Run, &opt, bad.Target(), bad.Line)
 	if err != nil {
 		err = fmt.Errorf("%w, output=%s", err, out)
 		sj.jailFailCounter.Incr()
@@ -208,6 +262,22 @@ func (sj *ShellJail) Arrest(bad BadLog, log Logger) error {
 	return nil
 }
 
+// Release runs the release script, it's a no-op without the script.
+func (sj *ShellJail) Release(bad BadLog, log Logger) error {
+	if sj.ReleaseRun == "" {
+		return nil
+	}
+	opt := ScriptOption{
+		YAMLScriptOption: sj.YAMLScriptOption,
+		Env:              bad.AsEnv(),
+	}
+	out, err := RunScript(sj.ReleaseRun, &opt, bad.Target(), bad.Line)
+	if err != nil {
+		return fmt.Errorf("%w, output=%s", err, out)
+	}
+	return nil
+}
+
 func (sj *ShellJail) Close() error {
 	return nil
 }
@@ -234,7 +304,7 @@ func NewHTTPJail(decode Decoder) (Jailer, error) {
 }
 
 func (hj *HTTPJail) Arrest(bad BadLog, log Logger) error {
-	log.Debugf("[jail-%s] start arrest ip %s", hj.ID, bad.IP)
+	log.Debugf("[jail-%s] start arrest ip %s", hj.ID, bad.Target())
 	_, err := hj.HTTPHelper.Do(context.Background(), false, bad.Mapping)
 	if err != nil {
 		hj.jailFailCounter.Incr()
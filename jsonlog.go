@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+)
+
+func init() {
+	RegisterDiscipliner("json", NewJSONDiscipline)
+}
+
+// JSONCondition tests a field selected by json pointer.
+type JSONCondition struct {
+	Pointer   string `yaml:"pointer"`
+	FieldTest `yaml:",inline"`
+
+	path []string
+}
+
+type jsonConditionYAML JSONCondition
+
+func (c *JSONCondition) UnmarshalYAML(b []byte) error {
+	var v jsonConditionYAML
+	if err := YamlDecode(b, &v); err != nil {
+		return err
+	}
+	if !strings.HasPrefix(v.Pointer, "/") {
+		return fmt.Errorf("bad json pointer: %q", v.Pointer)
+	}
+	if v.FieldTest.Empty() {
+		return fmt.Errorf("condition of %s has nothing to test", v.Pointer)
+	}
+	*c = JSONCondition(v)
+	c.path = parseJSONPointer(c.Pointer)
+	return nil
+}
+
+func (c *JSONCondition) TestJSON(object any) bool {
+	return c.Test(visitJSON(object, c.path...))
+}
+
+// JSONDiscipline judges json lines, ip is read from a json pointer.
+type JSONDiscipline struct {
+	BaseDiscipline `yaml:",inline"`
+	IP             string            `yaml:"ip"`
+	Conditions     []*JSONCondition  `yaml:"conditions,omitempty"`
+	Fields         map[string]string `yaml:"fields,omitempty"`
+	RateJudge      `yaml:",inline"`
+
+	ipPath     []string
+	fieldNames []string
+	fieldPaths map[string][]string
+}
+
+func NewJSONDiscipline(decode Decoder) (Discipliner, error) {
+	var jd JSONDiscipline
+	if err := decode(&jd); err != nil {
+		return nil, err
+	}
+	id := jd.ID
+	if !strings.HasPrefix(jd.IP, "/") {
+		return nil, fmt.Errorf("[discipline-%s] bad ip json pointer: %q", id, jd.IP)
+	}
+	jd.ipPath = parseJSONPointer(jd.IP)
+	jd.fieldPaths = map[string][]string{}
+	for name, pointer := range jd.Fields {
+		if name == "ip" {
+			return nil, fmt.Errorf("[discipline-%s] field name ip is reserved", id)
+		}
+		if !strings.HasPrefix(pointer, "/") {
+			return nil, fmt.Errorf("[discipline-%s] bad json pointer of field %s: %q", id, name, pointer)
+		}
+		jd.fieldNames = append(jd.fieldNames, name)
+		jd.fieldPaths[name] = parseJSONPointer(pointer)
+	}
+	slices.Sort(jd.fieldNames)
+	err := jd.RateJudge.CheckGroups(func(group string) bool {
+		return group == "ip" || slices.Contains(jd.fieldNames, group)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("[discipline-%s] %w in fields", id, err)
+	}
+	jd.RateJudge.Init(id)
+	return &jd, nil
+}
+
+func (jd *JSONDiscipline) Judge(line Line, allow Allows, logger Logger) (bad BadLog, ok bool) {
+	jd.Tail()
+	if line.Text == "" {
+		return bad, false
+	}
+	var object any
+	if err := json.Unmarshal([]byte(line.Text), &object); err != nil {
+		logger.Debugf("[discipline-%s][watch-%s] bad json: %v", jd.ID, line.WatchID, err)
+		return bad, false
+	}
+	for _, c := range jd.Conditions {
+		if !c.TestJSON(object) {
+			logger.Debugf("[discipline-%s][watch-%s] condition not match: %s", jd.ID, line.WatchID, c.Pointer)
+			return bad, false
+		}
+	}
+	ip := visitJSON(object, jd.ipPath...)
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	groups := KeyValueList{{Key: "ip", Value: ip}}
+	for _, name := range jd.fieldNames {
+		groups = append(groups, KeyValue{
+			Key:   name,
+			Value: visitJSON(object, jd.fieldPaths[name]...),
+		})
+	}
+	return jd.RateJudge.Judge(line, groups, allow, logger)
+}
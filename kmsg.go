@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+func init() {
+	RegisterWatcher("kmsg", NewKmsgWatch)
+}
+
+const defaultKmsgFile = "/dev/kmsg"
+
+// KmsgWatch reads kernel log records from /dev/kmsg.
+// A regular file containing one record per line is also accepted.
+type KmsgWatch struct {
+	BaseWatch `yaml:",inline"`
+	File      string `yaml:"file"`
+	FromStart bool   `yaml:"from_start"`
+	MaxLevel  *int   `yaml:"max_level,omitempty"`
+
+	ctx    context.Context
+	cancel func()
+	wg     sync.WaitGroup
+
+	linesCounter *Counter
+	lostCounter  *Counter
+}
+
+func NewKmsgWatch(decode Decoder) (Watcher, error) {
+	var k KmsgWatch
+	if err := decode(&k); err != nil {
+		return nil, err
+	}
+	if k.File == "" {
+		k.File = defaultKmsgFile
+	}
+	if k.MaxLevel != nil && (*k.MaxLevel < 0 || *k.MaxLevel > 7) {
+		return nil, fmt.Errorf("[watch-%s] max_level must between 0 and 7: %d", k.ID, *k.MaxLevel)
+	}
+	k.ctx, k.cancel = context.WithCancel(context.Background())
+	k.linesCounter = RegisterNewCounter("watch", k.ID, "lines")
+	k.lostCounter = RegisterNewCounter("watch", k.ID, "lost")
+	return &k, nil
+}
+
+type kmsgRecord struct {
+	Level     int
+	Facility  int
+	Seq       uint64
+	Timestamp time.Duration // since boot
+	Message   string
+}
+
+// parseKmsg parses record like "6,339,5140900,-;NET: Registered protocol family 10".
+// See https://www.kernel.org/doc/Documentation/ABI/testing/dev-kmsg
+func parseKmsg(s string) (r kmsgRecord, err error) {
+	header, msg, ok := strings.Cut(s, ";")
+	if !ok {
+		return r, fmt.Errorf("bad kmsg record: %q", s)
+	}
+	fields := strings.Split(header, ",")
+	if len(fields) < 3 {
+		return r, fmt.Errorf("bad kmsg header: %q", header)
+	}
+	prio, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return r, fmt.Errorf("bad kmsg priority: %q", fields[0])
+	}
+	r.Level = prio & 7
+	r.Facility = prio >> 3
+	r.Seq, err = strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return r, fmt.Errorf("bad kmsg sequence: %q", fields[1])
+	}
+	us, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return r, fmt.Errorf("bad kmsg timestamp: %q", fields[2])
+	}
+	r.Timestamp = time.Duration(us) * time.Microsecond
+	// continuation lines carry key/value dictionary, not needed.
+	msg, _, _ = strings.Cut(msg, "\n")
+	r.Message = msg
+	return r, nil
+}
+
+func (kw *KmsgWatch) Watch(logger Logger) (<-chan Line, error) {
+	return kw.watch(logger, false)
+}
+
+func (kw *KmsgWatch) Test(logger Logger) (<-chan Line, error) {
+	return kw.watch(logger, true)
+}
+
+func (kw *KmsgWatch) watch(logger Logger, testing bool) (<-chan Line, error) {
+	logger.Debugf("[watch-%s] watch starting", kw.ID)
+	f, err := os.Open(kw.File)
+	if err != nil {
+		return nil, fmt.Errorf("[watch-%s] %w", kw.ID, err)
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("[watch-%s] %w", kw.ID, err)
+	}
+	device := stat.Mode()&os.ModeCharDevice != 0
+	if !kw.FromStart && !testing {
+		if _, err := f.Seek(0, io.SeekEnd); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("[watch-%s] seek to end fail: %w", kw.ID, err)
+		}
+	}
+	ch := NewChan[Line](0)
+	kw.wg.Add(1)
+	go func() {
+		defer func() {
+			kw.cancel()
+			kw.wg.Done()
+		}()
+		var read func() (string, error)
+		if device {
+			read = kw.recordReader(f, testing)
+		} else {
+			read = lineReader(f)
+		}
+		var lastSeq uint64
+		for {
+			s, err := read()
+			if errors.Is(err, syscall.EPIPE) {
+				// records were overwritten before we read them.
+				logger.Infof("[watch-%s] kmsg records lost", kw.ID)
+				kw.lostCounter.Incr()
+				continue
+			}
+			if err != nil {
+				if kw.ctx.Err() == nil && !errors.Is(err, io.EOF) && !errors.Is(err, os.ErrDeadlineExceeded) {
+					logger.Errorf("[watch-%s] read kmsg fail: %v", kw.ID, err)
+				}
+				logger.Infof("[watch-%s] kmsg closed: %s", kw.ID, kw.File)
+				return
+			}
+			r, err := parseKmsg(s)
+			if err != nil {
+				logger.Debugf("[watch-%s] %v", kw.ID, err)
+				continue
+			}
+			if lastSeq > 0 && r.Seq > lastSeq+1 {
+				logger.Infof("[watch-%s] kmsg records lost: %d", kw.ID, r.Seq-lastSeq-1)
+				kw.lostCounter.Incr()
+			}
+			lastSeq = r.Seq
+			if kw.MaxLevel != nil && r.Level > *kw.MaxLevel {
+				continue
+			}
+			logger.Debugf("[watch-%s] get kmsg(level=%d seq=%d ts=%s): '%s'", kw.ID, r.Level, r.Seq, r.Timestamp, r.Message)
+			if err := ch.Send(NewLine(kw.ID, r.Message)); err != nil {
+				return
+			}
+			kw.linesCounter.Incr()
+		}
+	}()
+	kw.wg.Add(1)
+	go func() {
+		defer kw.wg.Done()
+		<-kw.ctx.Done()
+		ch.Close()
+		f.Close()
+	}()
+	return ch.Reader(), nil
+}
+
+// recordReader reads one record per read call from kmsg device.
+// In testing mode reading stops after the buffer is drained.
+func (kw *KmsgWatch) recordReader(f *os.File, testing bool) func() (string, error) {
+	buf := make([]byte, 8192)
+	return func() (string, error) {
+		if testing {
+			if err := f.SetReadDeadline(time.Now().Add(time.Millisecond * 100)); err != nil {
+				return "", err
+			}
+		}
+		n, err := f.Read(buf)
+		if err != nil {
+			return "", err
+		}
+		return string(buf[:n]), nil
+	}
+}
+
+func lineReader(r io.Reader) func() (string, error) {
+	scan := bufio.NewScanner(r)
+	return func() (string, error) {
+		if scan.Scan() {
+			return scan.Text(), nil
+		}
+		if err := scan.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+}
+
+func (kw *KmsgWatch) Close() error {
+	kw.cancel()
+	kw.wg.Wait()
+	return nil
+}
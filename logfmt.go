@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+func init() {
+	RegisterDiscipliner("logfmt", NewLogfmtDiscipline)
+}
+
+// LogfmtRule tests the value of key.
+type LogfmtRule struct {
+	Key       string `yaml:"key"`
+	FieldTest `yaml:",inline"`
+}
+
+type logfmtRuleYAML LogfmtRule
+
+func (r *LogfmtRule) UnmarshalYAML(b []byte) error {
+	var v logfmtRuleYAML
+	if err := YamlDecode(b, &v); err != nil {
+		return err
+	}
+	if v.Key == "" {
+		return fmt.Errorf("rule key is empty")
+	}
+	if v.FieldTest.Empty() {
+		return fmt.Errorf("rule of %s has nothing to test", v.Key)
+	}
+	*r = LogfmtRule(v)
+	return nil
+}
+
+// LogfmtDiscipline judges key=value lines, ip is read from a key.
+// All pairs are passed to jails as named groups.
+type LogfmtDiscipline struct {
+	BaseDiscipline `yaml:",inline"`
+	IP             string        `yaml:"ip"`
+	Rules          []*LogfmtRule `yaml:"rules,omitempty"`
+	RateJudge      `yaml:",inline"`
+}
+
+func NewLogfmtDiscipline(decode Decoder) (Discipliner, error) {
+	var ld LogfmtDiscipline
+	if err := decode(&ld); err != nil {
+		return nil, err
+	}
+	if ld.IP == "" {
+		return nil, fmt.Errorf("[discipline-%s] ip key is empty", ld.ID)
+	}
+	ld.RateJudge.Init(ld.ID)
+	return &ld, nil
+}
+
+func (ld *LogfmtDiscipline) Judge(line Line, allow Allows, logger Logger) (bad BadLog, ok bool) {
+	ld.Tail()
+	if line.Text == "" {
+		return bad, false
+	}
+	pairs := parseLogfmt(line.Text)
+	if len(pairs) == 0 {
+		logger.Debugf("[discipline-%s][watch-%s] no key value found: length=%d", ld.ID, line.WatchID, len(line.Text))
+		return bad, false
+	}
+	for _, r := range ld.Rules {
+		if !r.Test(pairs.Get(r.Key)) {
+			logger.Debugf("[discipline-%s][watch-%s] rule not match: %s", ld.ID, line.WatchID, r.Key)
+			return bad, false
+		}
+	}
+	ip := pairs.Get(ld.IP)
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	groups := append(KeyValueList{{Key: "ip", Value: ip}}, pairs...)
+	return ld.RateJudge.Judge(line, groups, allow, logger)
+}
+
+// parseLogfmt tokenizes line like `ts=2025-01-01 level=info msg="bad login" user=bob`.
+// Values could be double quoted with backslash escapes, key without value gets empty value.
+func parseLogfmt(s string) KeyValueList {
+	var (
+		pairs KeyValueList
+		i     int
+	)
+	isSpace := func(c byte) bool {
+		return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+	}
+	for i < len(s) {
+		for i < len(s) && isSpace(s[i]) {
+			i++
+		}
+		start := i
+		for i < len(s) && s[i] != '=' && !isSpace(s[i]) {
+			i++
+		}
+		key := s[start:i]
+		if i >= len(s) || s[i] != '=' {
+			if key != "" {
+				pairs = append(pairs, KeyValue{Key: key})
+			}
+			continue
+		}
+		i++ // skip '='
+		var value string
+		if i < len(s) && s[i] == '"' {
+			var bs strings.Builder
+			i++
+			for i < len(s) && s[i] != '"' {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+					switch s[i] {
+					case 'n':
+						bs.WriteByte('\n')
+					case 't':
+						bs.WriteByte('\t')
+					case 'r':
+						bs.WriteByte('\r')
+					default:
+						bs.WriteByte(s[i])
+					}
+				} else {
+					bs.WriteByte(s[i])
+				}
+				i++
+			}
+			i++ // skip closing quote
+			value = bs.String()
+		} else {
+			start := i
+			for i < len(s) && !isSpace(s[i]) {
+				i++
+			}
+			value = s[start:i]
+		}
+		if key != "" {
+			pairs = append(pairs, KeyValue{Key: key, Value: value})
+		}
+	}
+	return pairs
+}
@@ -48,6 +48,8 @@ func init() {
 		&testConfigCommand,
 		&testMailCommand,
 		&ipLocationCommand,
+		&presetsCommand,
+		&importFail2banCommand,
 	)
 	for _, c := range commands {
 		c.init()
@@ -142,6 +144,8 @@ var testConfigCommand = Command[testConfigOptions]{
 type testRegexOptions struct {
 	TestRegexMatch  Multi
 	TestRegexIgnore Multi
+	Patterns        Multi
+	ConfigDir       string
 }
 
 var testRegexCommand = Command[testRegexOptions]{
@@ -154,6 +158,8 @@ var testRegexCommand = Command[testRegexOptions]{
 		flag := &c.FlagSet
 		flag.Var(&opt.TestRegexMatch, "match", "match pattern, could provides many times.")
 		flag.Var(&opt.TestRegexIgnore, "ignore", "ignore pattern, could provides many times.")
+		flag.Var(&opt.Patterns, "pattern", "define pattern macro as name=regex, could provides many times.")
+		flag.StringVar(&opt.ConfigDir, "config-dir", "", "load pattern macros from config files in directory.")
 	},
 	Run: func(c *Command[testRegexOptions]) error {
 		return runTestRegex(&c.Options, c.FlagSet.Arg(0))
@@ -203,6 +209,39 @@ var ipLocationCommand = Command[ipLocationOptions]{
 	},
 }
 
+type presetsOptions struct{}
+
+var presetsCommand = Command[presetsOptions]{
+	Name:             "presets",
+	ShortUsage:       "presets list | presets show <name>",
+	ShortDescription: "list built-in discipline presets or print yaml of a preset.",
+	LongDescription:  "A discipline uses a preset by 'preset: <name>', keys of the discipline override the preset.",
+	NArgs:            -1,
+	Init:             func(c *Command[presetsOptions]) {},
+	Run: func(c *Command[presetsOptions]) error {
+		return runPresets(c.FlagSet.Args()...)
+	},
+}
+
+type importFail2banOptions struct {
+	Output string
+}
+
+var importFail2banCommand = Command[importFail2banOptions]{
+	Name:             "import-fail2ban",
+	ShortUsage:       "import-fail2ban [OPTION]... <DIR>",
+	ShortDescription: "convert fail2ban filters and jails to go2jail config.",
+	LongDescription: "DIR is a fail2ban config directory like /etc/fail2ban, or a filter.d directory.\n" +
+		"Patterns could not be converted are reported to stderr.",
+	NArgs: 1,
+	Init: func(c *Command[importFail2banOptions]) {
+		c.FlagSet.StringVar(&c.Options.Output, "o", "", "write config to file instead of stdout.")
+	},
+	Run: func(c *Command[importFail2banOptions]) error {
+		return runImportFail2ban(&c.Options, c.FlagSet.Arg(0))
+	},
+}
+
 type Command[T any] struct {
 	Name             string
 	ShortUsage       string
@@ -325,6 +364,15 @@ func (flags *configFlags) getConfig() (*Config, error) {
 	if flags.StrictConfig {
 		YAMLStrict = true
 	}
+	configs, err := flags.files()
+	if err != nil {
+		return nil, err
+	}
+	return Parse(configs...)
+}
+
+// files returns yaml files of config directory.
+func (flags *configFlags) files() ([]string, error) {
 	entries, err := os.ReadDir(flags.ConfigDir)
 	if err != nil {
 		return nil, err
@@ -341,7 +389,7 @@ func (flags *configFlags) getConfig() (*Config, error) {
 	if len(configs) == 0 {
 		return nil, fmt.Errorf("cannot find config in %s", flags.ConfigDir)
 	}
-	return Parse(configs...)
+	return configs, nil
 }
 
 func entrypoint(args []string) error {
@@ -387,6 +435,7 @@ func waitAndHandleSignal(wait, stop func()) {
 }
 
 var (
+	Stdin  io.Reader = os.Stdin
 	Stdout io.Writer = os.Stdout
 	Stderr io.Writer = os.Stderr
 )
@@ -466,22 +515,45 @@ func runTestRegex(flags *testRegexOptions, file string) error {
 	}
 	defer f.Close()
 
-	b, _ := json.Marshal(flags.TestRegexMatch.Values)
+	patterns := Patterns{}
+	if flags.ConfigDir != "" {
+		files, err := (&configFlags{ConfigDir: flags.ConfigDir}).files()
+		if err != nil {
+			return err
+		}
+		if patterns, err = readPatterns(files...); err != nil {
+			return err
+		}
+	}
+	for _, v := range flags.Patterns.Values {
+		name, value, ok := strings.Cut(v, "=")
+		if !ok {
+			return fmt.Errorf("bad pattern, name=regex expected: %s", v)
+		}
+		patterns[name] = value
+	}
 	var (
 		match   Matcher
 		ignores Matcher
 	)
-	if err := match.UnmarshalYAML(b); err != nil {
-		return err
-	}
-	if err := match.ExpectGroups("ip"); err != nil {
-		return err
-	}
-	if flags.TestRegexIgnore.Values != nil {
-		b, _ := json.Marshal(flags.TestRegexIgnore.Values)
-		if err := ignores.UnmarshalYAML(b); err != nil {
+	err = WithPatterns(patterns, func() error {
+		b, _ := json.Marshal(flags.TestRegexMatch.Values)
+		if err := match.UnmarshalYAML(b); err != nil {
+			return err
+		}
+		if err := match.ExpectGroups("ip"); err != nil {
 			return err
 		}
+		if flags.TestRegexIgnore.Values != nil {
+			b, _ := json.Marshal(flags.TestRegexIgnore.Values)
+			if err := ignores.UnmarshalYAML(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	fmt.Println(match.MarshalYAML())
@@ -500,6 +572,41 @@ func runTestRegex(flags *testRegexOptions, file string) error {
 	return scan.Err()
 }
 
+func runPresets(args ...string) error {
+	switch {
+	case len(args) == 1 && args[0] == "list":
+		for _, name := range PresetNames() {
+			b, _ := Preset(name)
+			fmt.Fprintf(Stdout, "%-20s %s\n", name, PresetDescription(b))
+		}
+		return nil
+	case len(args) == 2 && args[0] == "show":
+		b, err := Preset(args[1])
+		if err != nil {
+			return err
+		}
+		_, err = Stdout.Write(b)
+		return err
+	}
+	return fmt.Errorf("bad arguments. \n%s", badUsageHelp)
+}
+
+func runImportFail2ban(opt *importFail2banOptions, dir string) error {
+	cfg, problems, err := importFail2ban(dir)
+	for _, p := range problems {
+		fmt.Fprintln(Stderr, "WARNING:", p)
+	}
+	if err != nil {
+		return err
+	}
+	content := fmt.Sprintf("# generated by go2jail import-fail2ban from %s\n%s", dir, YamlEncode(cfg))
+	if opt.Output == "" {
+		_, err = io.WriteString(Stdout, content)
+		return err
+	}
+	return os.WriteFile(opt.Output, []byte(content), 0644)
+}
+
 func runTestMail(cfg *testMailOptions, id ...string) error {
 	c, err := cfg.configFlags.getConfig()
 	if err != nil {
@@ -13,6 +13,7 @@ import (
 	"os/user"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"text/template"
 	"time"
@@ -20,11 +21,22 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
+// testNftsetJail is an nftset jail named by the test,
+// configs include it by {{template "nftset" .}} under jails.
+const testNftsetJail = `  - id: '{{.Name}}'
+    type: nftset
+    rule: inet
+    table: filter
+    ipv4_set: ipv4_block_set
+    ipv6_set: ipv6_block_set`
+
 func makeTestConfig(t *testing.T, content string, options ...string) string {
 	testSetStrictConfig(t)
 	t.Helper()
 	tpl, err := template.New(t.Name()).Parse(content)
 	require.NoError(t, err)
+	_, err = tpl.New("nftset").Parse(testNftsetJail)
+	require.NoError(t, err)
 	dir, err := os.MkdirTemp("", "go2jail-test-*")
 	require.NoError(t, err)
 	t.Cleanup(func() {
@@ -122,10 +134,27 @@ func testWaitNftLogWrite(t *testing.T, dir string) string {
 	return nftlog
 }
 
-func testRunDaemon(t *testing.T,
-	configContent, LinesContent, expect string, options ...string) string {
-	wait, stop, dir := testStartDaemon(t, configContent, LinesContent, options...)
+func testWaitNftLogContent(t *testing.T, nftlog, expect string) {
+	t.Helper()
+	var b []byte
+	for range 50 {
+		var err error
+		b, err = os.ReadFile(nftlog)
+		require.NoError(t, err)
+		if string(b) == expect {
+			return
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	t.Fatalf("waiting for nft log content timeout, got:\n%s\nexpect:\n%s", b, expect)
+}
+
+// testStopDaemon waits for the nft log of dir to be expect, then stops the daemon
+// and checks nothing more is written.
+func testStopDaemon(t *testing.T, wait, stop func(), dir, expect string) {
+	t.Helper()
 	nftlog := testWaitNftLogWrite(t, dir)
+	testWaitNftLogContent(t, nftlog, expect)
 	t.Log("stopping...")
 	stop()
 	t.Log("waiting...")
@@ -134,9 +163,30 @@ func testRunDaemon(t *testing.T,
 	b, err := os.ReadFile(nftlog)
 	require.NoError(t, err)
 	require.Equal(t, expect, string(b))
+}
+
+func testRunDaemon(t *testing.T,
+	configContent, LinesContent, expect string, options ...string) string {
+	wait, stop, dir := testStartDaemon(t, configContent, LinesContent, options...)
+	testStopDaemon(t, wait, stop, dir, expect)
 	return dir
 }
 
+// testRequireCounters checks counters of group and id by name,
+// counters are kept after the daemon stops until next daemon starts.
+func testRequireCounters(t *testing.T, group, id string, expect map[string]int) {
+	t.Helper()
+	var bs bytes.Buffer
+	require.NoError(t, OutputCounters(&bs))
+	var d map[string]map[string]map[string]int
+	require.NoError(t, json.Unmarshal(bs.Bytes(), &d))
+	for name, v := range expect {
+		got, ok := d[group][id][name]
+		require.True(t, ok, "%s-%s-%s, json=%s", group, id, name, bs.String())
+		require.Equal(t, v, got, "%s-%s-%s, json=%s", group, id, name, bs.String())
+	}
+}
+
 func TestBasicLogAndNftReject(t *testing.T) {
 	cfg := `
 jails:
@@ -167,6 +217,178 @@ add element inet filter ipv4_block_set { 2.2.2.2 }
 	testRunDaemon(t, cfg, lines, expect)
 }
 
+func TestAggregateWorks(t *testing.T) {
+	cfg := `
+jails:
+{{template "nftset" .}}
+watches:
+  - id: '{{.Name}}'
+    type: file
+    files: [{{.dir}}/test.log]
+disciplines:
+  - id: '{{.Name}}'
+    jails: ['{{.Name}}']
+    watches: ['{{.Name}}']
+    matches: '%(ip)'
+    rate: 1/1s
+    aggregate:
+      min: 3
+      within: 1m
+`
+	lines := `1.1.1.1
+1.1.1.2
+2.2.2.2
+1.1.1.3
+2001:db8::1
+2001:db8::2
+2001:db8:1::1
+2001:db8::3
+1.1.1.4`
+	expect := `add element inet filter ipv4_block_set { 1.1.1.1 }
+add element inet filter ipv4_block_set { 1.1.1.2 }
+add element inet filter ipv4_block_set { 2.2.2.2 }
+add element inet filter ipv4_block_set { 1.1.1.0/24 }
+add element inet filter ipv6_block_set { 2001:db8::1 }
+add element inet filter ipv6_block_set { 2001:db8::2 }
+add element inet filter ipv6_block_set { 2001:db8:1::1 }
+add element inet filter ipv6_block_set { 2001:db8::/64 }
+add element inet filter ipv4_block_set { 1.1.1.4 }
+`
+	testRunDaemon(t, cfg, lines, expect)
+}
+
+func TestRecidiveWorks(t *testing.T) {
+	cfg := `
+jails:
+{{template "nftset" .}}
+  - id: '{{.Name}}-week'
+    type: nftset
+    rule: inet
+    table: filter
+    ipv4_set: ipv4_week_set
+    ipv6_set: ipv6_week_set
+watches:
+  - id: '{{.Name}}'
+    type: file
+    files: [{{.dir}}/test.log]
+disciplines:
+  - id: '{{.Name}}-sshd'
+    jails: ['{{.Name}}']
+    watches: ['{{.Name}}']
+    matches: '^sshd %(ip)'
+    rate: 1/1s
+  - id: '{{.Name}}-nginx'
+    jails: ['{{.Name}}']
+    watches: ['{{.Name}}']
+    matches: '^nginx %(ip)'
+    rate: 1/1s
+  - id: '{{.Name}}'
+    type: recidive
+    jails: ['{{.Name}}-week']
+    disciplines: ['{{.Name}}-sshd', '{{.Name}}-nginx']
+    rate: 1/1m
+    distinct:
+      group: discipline
+      min: 2
+      within: 1m
+`
+	lines := `sshd 1.1.1.1
+sshd 2.2.2.2
+sshd 2.2.2.2
+nginx 1.1.1.1`
+	expect := `add element inet filter ipv4_block_set { 1.1.1.1 }
+add element inet filter ipv4_block_set { 2.2.2.2 }
+add element inet filter ipv4_block_set { 2.2.2.2 }
+add element inet filter ipv4_block_set { 1.1.1.1 }
+add element inet filter ipv4_week_set { 1.1.1.1 }
+`
+	testRunDaemon(t, cfg, lines, expect)
+}
+
+func TestFeedWorks(t *testing.T) {
+	var n atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch n.Add(1) {
+		case 1:
+			fmt.Fprint(w, "; Spamhaus DROP List\n1.10.16.0/20 ; SBL256894\n2.2.2.2 ; SBL1\n127.0.0.1\n10.0.0.0/8\n")
+		case 2:
+			fmt.Fprint(w, "; Spamhaus DROP List\n2.2.2.2 ; SBL1\n2001:db8::/32 ; SBL2\n")
+		default:
+			// an error page must not release the feed.
+			fmt.Fprint(w, "<html><body>Service Unavailable</body></html>\n")
+		}
+	}))
+	defer server.Close()
+	// 9.9.9.9 is removed from the feed while the daemon is down.
+	state := filepath.Join(t.TempDir(), "feed.state")
+	require.NoError(t, os.WriteFile(state, []byte("2.2.2.2/32\n9.9.9.9/32\n"), 0o644))
+	cfg := fmt.Sprintf(`
+jails:
+{{template "nftset" .}}
+allows:
+  - 10.1.2.0/24
+feeds:
+  - id: '{{.Name}}'
+    source: url:%s
+    format: drop
+    refresh: 1s
+    state: %s
+    jails: ['{{.Name}}']
+`, server.URL, state)
+	expect := `add element inet filter ipv4_block_set { 1.10.16.0/20, 2.2.2.2 }
+delete element inet filter ipv4_block_set { 9.9.9.9 }
+add element inet filter ipv6_block_set { 2001:db8::/32 }
+delete element inet filter ipv4_block_set { 1.10.16.0/20 }
+`
+	wait, stop, dir := testStartDaemon(t, cfg, "")
+	require.Eventually(t, func() bool { return n.Load() >= 3 }, 5*time.Second, 100*time.Millisecond)
+	testStopDaemon(t, wait, stop, dir, expect)
+	b, err := os.ReadFile(state)
+	require.NoError(t, err)
+	require.Equal(t, "# networks of feed "+t.Name()+"\n2.2.2.2/32\n2001:db8::/32\n", string(b))
+}
+
+func TestFeedRetryArrest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "1.1.1.1\n")
+	}))
+	defer server.Close()
+	cfg := fmt.Sprintf(`
+jails:
+  - id: '{{.Name}}'
+    type: shell
+    run: |
+      if [ ! -e {{.dir}}/failed ]; then touch {{.dir}}/failed; exit 1; fi
+      nft "$1"
+feeds:
+  - id: '{{.Name}}'
+    source: url:%s
+    refresh: 1s
+    jails: ['{{.Name}}']
+`, server.URL)
+	testRunDaemon(t, cfg, "", "1.1.1.1\n")
+}
+
+func TestParseFeed(t *testing.T) {
+	for _, c := range []struct {
+		format, content string
+		expect          []string
+	}{
+		{"netset", "# FireHOL level1\n1.0.0.0/8\n2.2.2.2\n", []string{"1.0.0.0/8", "2.2.2.2/32"}},
+		{"drop", "; DROP\n1.10.16.0/20 ; SBL256894\n", []string{"1.10.16.0/20"}},
+		{"abuseipdb", "countryCode,ipAddress,abuseConfidenceScore\nCN,3.3.3.3,100\nUS,2001:db8::1,90\n", []string{"3.3.3.3/32", "2001:db8::1/128"}},
+		{"auto", "3.3.3.3,100\n# comment\n4.4.4.0/24 ; x\n", []string{"3.3.3.3/32", "4.4.4.0/24"}},
+	} {
+		nets, err := ParseFeed(c.format, []byte(c.content))
+		require.NoError(t, err, c.format)
+		var ss []string
+		for _, n := range nets {
+			ss = append(ss, n.String())
+		}
+		require.Equal(t, c.expect, ss, c.format)
+	}
+}
+
 func TestCountersWorks(t *testing.T) {
 	cfg := `
 jails:
@@ -195,39 +417,78 @@ disciplines:
 	expect := `add element inet filter ipv4_block_set { 1.1.1.1 }
 add element inet filter ipv4_block_set { 2.2.2.2 }
 `
-	wait, stop, dir := testStartDaemon(t, cfg, lines)
-	nftlog := testWaitNftLogWrite(t, dir)
-	var bs bytes.Buffer
-	err := OutputCounters(&bs)
-	require.NoError(t, err)
-	t.Log("stopping...")
-	stop()
-	t.Log("waiting...")
-	wait()
-	t.Log("read nft log")
-	b, err := os.ReadFile(nftlog)
-	require.NoError(t, err)
-	require.Equal(t, expect, string(b))
+	testRunDaemon(t, cfg, lines, expect)
+	testRequireCounters(t, "watch", t.Name(), map[string]int{"lines": 3})
+	testRequireCounters(t, "discipline", t.Name(), map[string]int{
+		"tail_lines":  3,
+		"match_lines": 3,
+		"bad_ip":      0,
+		"allow_ip":    1,
+		"watch_ip":    0,
+		"arrest_ip":   2,
+	})
+	testRequireCounters(t, "jail", t.Name(), map[string]int{"success": 2, "fail": 0})
+}
 
-	var d map[string]map[string]map[string]int
-	err = json.Unmarshal(bs.Bytes(), &d)
-	require.NoError(t, err)
-	testEqual := func(group, id, name string, expect int) {
-		t.Helper()
-		v, ok := d[group][id][name]
-		require.True(t, ok, bs.String())
-		require.Equal(t, expect, v, "%s-%s-%s, json=%s", group, id, name, bs.String())
-	}
+func TestDisciplineAllowsFile(t *testing.T) {
+	allows := filepath.Join(t.TempDir(), "allows.txt")
+	require.NoError(t, os.WriteFile(allows, []byte("# trusted\n1.1.1.0/24\n"), 0o644))
+	cfg := fmt.Sprintf(`
+jails:
+{{template "nftset" .}}
+watches:
+  - id: '{{.Name}}'
+    type: file
+    files: [{{.dir}}/test.log]
+disciplines:
+  - id: '{{.Name}}'
+    jails: ['{{.Name}}']
+    watches: ['{{.Name}}']
+    matches: '%%(ip)'
+    allows: ['file:%s']
+`, allows)
+	lines := `1.1.1.1
+2.2.2.2`
+	expect := `add element inet filter ipv4_block_set { 2.2.2.2 }
+`
+	testRunDaemon(t, cfg, lines, expect)
+}
 
-	testEqual("watch", t.Name(), "lines", 3)
-	testEqual("discipline", t.Name(), "tail_lines", 3)
-	testEqual("discipline", t.Name(), "match_lines", 3)
-	testEqual("discipline", t.Name(), "bad_ip", 0)
-	testEqual("discipline", t.Name(), "allow_ip", 1)
-	testEqual("discipline", t.Name(), "watch_ip", 0)
-	testEqual("discipline", t.Name(), "arrest_ip", 2)
-	testEqual("jail", t.Name(), "success", 2)
-	testEqual("jail", t.Name(), "fail", 0)
+func TestDispatchWorks(t *testing.T) {
+	cfg := `
+jails:
+{{template "nftset" .}}
+watches:
+  - id: '{{.Name}}'
+    type: file
+    files: [{{.dir}}/test.log]
+disciplines:
+  - id: '{{.Name}}-password'
+    jails: ['{{.Name}}']
+    watches: ['{{.Name}}']
+    matches: 'Failed password for .* from %(ip)'
+  - id: '{{.Name}}-user'
+    jails: ['{{.Name}}']
+    watches: ['{{.Name}}']
+    matches: '(?i)invalid user .* from %(ip)'
+  - id: '{{.Name}}-any'
+    jails: ['{{.Name}}']
+    watches: ['{{.Name}}']
+    matches: 'blocked %(ip)'
+    ignores: 'blocked 3'
+`
+	lines := `Failed password for root from 1.1.1.1
+Invalid user admin from 2.2.2.2
+connection closed by 3.3.3.3
+blocked 4.4.4.4`
+	expect := `add element inet filter ipv4_block_set { 1.1.1.1 }
+add element inet filter ipv4_block_set { 2.2.2.2 }
+add element inet filter ipv4_block_set { 4.4.4.4 }
+`
+	testRunDaemon(t, cfg, lines, expect)
+	for _, id := range []string{"password", "user", "any"} {
+		testRequireCounters(t, "discipline", t.Name()+"-"+id, map[string]int{"tail_lines": 4, "match_lines": 1})
+	}
 }
 
 func TestLogDisciplineRateWorks(t *testing.T) {
@@ -540,3 +801,736 @@ disciplines:
 		wait()
 	}
 }
+
+func TestContainerWatch(t *testing.T) {
+	dir := makeTestConfig(t, `
+jails:
+  - id: '{{.Name}}'
+    type: echo
+watches:
+  - id: '{{.Name}}'
+    type: container
+    root: {{.dir}}/containers
+    names: [web]
+    labels: [role=front]
+    streams: [stdout]
+disciplines:
+  - id: '{{.Name}}'
+    jails: ['{{.Name}}']
+    watches: ['{{.Name}}']
+    matches: ['%(ip) (?P<user>\w+)$']
+    rate: 1/s
+`)
+	writeContainer := func(id, name, labels string, lines ...string) {
+		d := filepath.Join(dir, "containers", id)
+		require.NoError(t, os.MkdirAll(d, 0755))
+		config := fmt.Sprintf(`{"ID":%q,"Name":"/%s","Config":{"Labels":%s}}`, id, name, labels)
+		require.NoError(t, os.WriteFile(filepath.Join(d, "config.v2.json"), []byte(config), 0644))
+		var bs strings.Builder
+		for _, l := range lines {
+			b, err := json.Marshal(map[string]string{
+				"log": l, "stream": "stdout", "time": "2025-01-01T00:00:00.000000000Z",
+			})
+			require.NoError(t, err)
+			bs.Write(b)
+			bs.WriteByte('\n')
+		}
+		require.NoError(t, os.WriteFile(filepath.Join(d, id+"-json.log"), []byte(bs.String()), 0644))
+	}
+	writeContainer("aaa", "web", `{"role":"front"}`, "1.1.1.1 user1\n", "2.2.2.2 us", "er2\n")
+	writeContainer("bbb", "db", `{"role":"front"}`, "3.3.3.3 user3\n")
+	writeContainer("ccc", "web", `{"role":"back"}`, "4.4.4.4 user4\n")
+
+	stdout := Stdout
+	t.Cleanup(func() {
+		Stdout = stdout
+	})
+	var bs strings.Builder
+	Stdout = &bs
+	var opt testDisciplineOption
+	opt.ConfigDir = dir
+	opt.LogLevel = "debug"
+	wait, _, err := runTestDiscipline(&opt, t.Name())
+	require.NoError(t, err)
+	wait()
+	require.Equal(t, `1.1.1.1 1.1.1.1 user1
+2.2.2.2 2.2.2.2 user2
+`, bs.String())
+}
+
+func TestContainerWatchEventTime(t *testing.T) {
+	dir := makeTestConfig(t, `
+jails:
+  - id: '{{.Name}}'
+    type: echo
+watches:
+  - id: '{{.Name}}'
+    type: container
+    root: {{.dir}}/containers
+disciplines:
+  - id: '{{.Name}}'
+    jails: ['{{.Name}}']
+    watches: ['{{.Name}}']
+    matches: ['%(ip)']
+    rate: 2/1m
+`)
+	d := filepath.Join(dir, "containers", "aaa")
+	require.NoError(t, os.MkdirAll(d, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(d, "config.v2.json"), []byte(`{"ID":"aaa","Name":"/web"}`), 0644))
+	// hits of 1.1.1.1 are an hour apart, only 2.2.2.2 reaches the rate by event time.
+	logs := `{"log":"1.1.1.1\n","stream":"stdout","time":"2025-01-01T00:00:00Z"}
+{"log":"2.2.2.2\n","stream":"stdout","time":"2025-01-01T00:00:01Z"}
+{"log":"2.2.2.2\n","stream":"stdout","time":"2025-01-01T00:00:02Z"}
+{"log":"1.1.1.1\n","stream":"stdout","time":"2025-01-01T01:00:00Z"}
+`
+	require.NoError(t, os.WriteFile(filepath.Join(d, "aaa-json.log"), []byte(logs), 0644))
+
+	stdout := Stdout
+	t.Cleanup(func() {
+		Stdout = stdout
+	})
+	var bs strings.Builder
+	Stdout = &bs
+	var opt testDisciplineOption
+	opt.ConfigDir = dir
+	opt.LogLevel = "info"
+	wait, _, err := runTestDiscipline(&opt, t.Name())
+	require.NoError(t, err)
+	wait()
+	require.Equal(t, "2.2.2.2 2.2.2.2\n", bs.String())
+}
+
+func TestHTTPWatch(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	require.NoError(t, ln.Close())
+	cfg := `
+jails:
+{{template "nftset" .}}
+watches:
+  - id: '{{.Name}}'
+    type: http
+    listen: '{{.addr}}'
+    path: /ingest
+    token: secret
+    max_body_size: 64
+    rate: 4/m
+disciplines:
+  - id: '{{.Name}}'
+    jails: ['{{.Name}}']
+    watches: ['{{.Name}}']
+    matches: '%(ip)'
+    rate: 1/s
+`
+	wait, stop, dir := testStartDaemon(t, cfg, "", "addr", addr)
+	post := func(token, contentType, body string) int {
+		req, err := http.NewRequest("POST", "http://"+addr+"/ingest", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", contentType)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	require.Equal(t, http.StatusUnauthorized, post("bad", "text/plain", "9.9.9.9\n"))
+	require.Equal(t, http.StatusNoContent, post("secret", "text/plain", "1.1.1.1\n2.2.2.2\n"))
+	require.Equal(t, http.StatusNoContent, post("secret", "application/json", `["3.3.3.3"]`))
+	require.Equal(t, http.StatusRequestEntityTooLarge, post("secret", "text/plain", strings.Repeat("4.4.4.4\n", 10)))
+	require.Equal(t, http.StatusTooManyRequests, post("secret", "text/plain", "5.5.5.5\n"))
+	// unauthorized requests are limited as well.
+	require.Equal(t, http.StatusTooManyRequests, post("bad", "text/plain", "9.9.9.9\n"))
+
+	expect := `add element inet filter ipv4_block_set { 1.1.1.1 }
+add element inet filter ipv4_block_set { 2.2.2.2 }
+add element inet filter ipv4_block_set { 3.3.3.3 }
+`
+	testStopDaemon(t, wait, stop, dir, expect)
+}
+
+func TestMultilineWatch(t *testing.T) {
+	dir := makeTestConfig(t, `
+jails:
+  - id: '{{.Name}}'
+    type: echo
+watches:
+  - id: '{{.Name}}'
+    type: file
+    files: [{{.dir}}/test.log]
+    multiline:
+      start: '^\d{4} '
+      max_lines: 3
+      separator: ' | '
+disciplines:
+  - id: '{{.Name}}'
+    jails: ['{{.Name}}']
+    watches: ['{{.Name}}']
+    matches: ['error from %(ip).*user=(?P<user>\w+)']
+    rate: 1/s
+`)
+	watchfile := filepath.Join(dir, "test.log")
+	err := os.WriteFile(watchfile, []byte(`2025 error from 1.1.1.1
+  at foo
+  user=alice
+2025 ok
+  user=root
+2025 error from 2.2.2.2
+  at foo
+  at bar
+  user=bob
+2025 error from 3.3.3.3
+  user=carol
+`), 0777)
+	require.NoError(t, err)
+
+	stdout := Stdout
+	t.Cleanup(func() {
+		Stdout = stdout
+	})
+	var bs strings.Builder
+	Stdout = &bs
+	var opt testDisciplineOption
+	opt.ConfigDir = dir
+	opt.LogLevel = "debug"
+	wait, _, err := runTestDiscipline(&opt, t.Name())
+	require.NoError(t, err)
+	wait()
+	require.Equal(t, `1.1.1.1 2025 error from 1.1.1.1 |   at foo |   user=alice
+3.3.3.3 2025 error from 3.3.3.3 |   user=carol
+`, bs.String())
+}
+
+func TestKmsgWatch(t *testing.T) {
+	dir := makeTestConfig(t, `
+jails:
+  - id: '{{.Name}}'
+    type: echo
+watches:
+  - id: '{{.Name}}'
+    type: kmsg
+    file: {{.dir}}/kmsg
+    max_level: 4
+disciplines:
+  - id: '{{.Name}}'
+    jails: ['{{.Name}}']
+    watches: ['{{.Name}}']
+    matches: ['portscan: .*SRC=%(ip) .*DPT=(?P<port>\d+)']
+    rate: 1/s
+`)
+	err := os.WriteFile(filepath.Join(dir, "kmsg"), []byte(`4,100,5140900,-;portscan: IN=eth0 OUT= SRC=1.1.1.1 DST=10.0.0.1 PROTO=TCP SPT=4000 DPT=22
+6,101,5140901,-;portscan: IN=eth0 OUT= SRC=2.2.2.2 DST=10.0.0.1 PROTO=TCP SPT=4000 DPT=23
+bad record
+4,105,5140902,c;portscan: IN=eth0 OUT= SRC=3.3.3.3 DST=10.0.0.1 PROTO=TCP SPT=4000 DPT=80
+`), 0644)
+	require.NoError(t, err)
+
+	stdout := Stdout
+	t.Cleanup(func() {
+		Stdout = stdout
+	})
+	var bs strings.Builder
+	Stdout = &bs
+	var opt testDisciplineOption
+	opt.ConfigDir = dir
+	opt.LogLevel = "debug"
+	wait, _, err := runTestDiscipline(&opt, t.Name())
+	require.NoError(t, err)
+	wait()
+	require.Equal(t, `1.1.1.1 portscan: IN=eth0 OUT= SRC=1.1.1.1 DST=10.0.0.1 PROTO=TCP SPT=4000 DPT=22
+3.3.3.3 portscan: IN=eth0 OUT= SRC=3.3.3.3 DST=10.0.0.1 PROTO=TCP SPT=4000 DPT=80
+`, bs.String())
+}
+
+func TestFifoWatch(t *testing.T) {
+	cfg := `
+jails:
+{{template "nftset" .}}
+watches:
+  - id: '{{.Name}}'
+    type: fifo
+    path: '{{.dir}}/test.fifo'
+disciplines:
+  - id: '{{.Name}}'
+    jails: ['{{.Name}}']
+    watches: ['{{.Name}}']
+    matches: '%(ip)'
+    rate: 1/s
+`
+	wait, stop, dir := testStartDaemon(t, cfg, "")
+	fifo := filepath.Join(dir, "test.fifo")
+	stat, err := os.Stat(fifo)
+	require.NoError(t, err)
+	require.NotZero(t, stat.Mode()&os.ModeNamedPipe)
+	for _, line := range []string{"1.1.1.1\n", "2.2.2.2\n"} {
+		f, err := os.OpenFile(fifo, os.O_WRONLY, 0)
+		require.NoError(t, err)
+		_, err = f.WriteString(line)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+	expect := `add element inet filter ipv4_block_set { 1.1.1.1 }
+add element inet filter ipv4_block_set { 2.2.2.2 }
+`
+	testStopDaemon(t, wait, stop, dir, expect)
+}
+
+func TestStdinWatch(t *testing.T) {
+	dir := makeTestConfig(t, `
+jails:
+  - id: '{{.Name}}'
+    type: echo
+watches:
+  - id: '{{.Name}}'
+    type: stdin
+disciplines:
+  - id: '{{.Name}}'
+    jails: ['{{.Name}}']
+    watches: ['{{.Name}}']
+    matches: ['%(ip)']
+    rate: 1/s
+`)
+	stdin := Stdin
+	stdout := Stdout
+	t.Cleanup(func() {
+		Stdin = stdin
+		Stdout = stdout
+	})
+	Stdin = strings.NewReader("1.1.1.1\nnothing\n2.2.2.2\n")
+	var bs strings.Builder
+	Stdout = &bs
+	var opt testDisciplineOption
+	opt.ConfigDir = dir
+	opt.LogLevel = "debug"
+	wait, _, err := runTestDiscipline(&opt, t.Name())
+	require.NoError(t, err)
+	wait()
+	require.Equal(t, `1.1.1.1 1.1.1.1
+2.2.2.2 2.2.2.2
+`, bs.String())
+}
+
+func TestJSONDiscipline(t *testing.T) {
+	cfg := `
+jails:
+  - id: '{{.Name}}'
+    type: shell
+    run: |
+      nft "$1" "$GO2JAIL_status" "$GO2JAIL_uri"
+watches:
+  - id: '{{.Name}}'
+    type: file
+    files: [{{.dir}}/test.log]
+disciplines:
+  - id: '{{.Name}}'
+    type: json
+    jails: ['{{.Name}}']
+    watches: ['{{.Name}}']
+    ip: /request/remote_ip
+    conditions:
+      - pointer: /status
+        min: 400
+        max: 499
+      - pointer: /request/method
+        in: [GET, POST]
+      - pointer: /request/uri
+        regex: '^/wp-'
+    fields:
+      status: /status
+      uri: /request/uri
+    rate: 1/s
+`
+	lines := `{"status":404,"request":{"method":"GET","remote_ip":"1.1.1.1","uri":"/wp-login.php"}}
+{"status":200,"request":{"method":"GET","remote_ip":"3.3.3.3","uri":"/wp-login.php"}}
+{"status":404,"request":{"method":"PUT","remote_ip":"4.4.4.4","uri":"/wp-login.php"}}
+{"status":404,"request":{"method":"GET","remote_ip":"5.5.5.5","uri":"/index.html"}}
+not json
+{"request":{"uri":"/wp-admin","remote_ip":"2.2.2.2:5000","method":"POST"},"status":401}`
+	expect := `1.1.1.1 404 /wp-login.php
+2.2.2.2 401 /wp-admin
+`
+	testRunDaemon(t, cfg, lines, expect)
+}
+
+func TestLogfmtDiscipline(t *testing.T) {
+	cfg := `
+jails:
+  - id: '{{.Name}}'
+    type: shell
+    run: |
+      nft "$1" "$GO2JAIL_user"
+watches:
+  - id: '{{.Name}}'
+    type: file
+    files: [{{.dir}}/test.log]
+disciplines:
+  - id: '{{.Name}}'
+    type: logfmt
+    jails: ['{{.Name}}']
+    watches: ['{{.Name}}']
+    ip: client
+    rules:
+      - key: status
+        min: 400
+      - key: msg
+        equals: 'login failed'
+    rate: 2/m
+`
+	lines := `client=1.1.1.1:4000 status=401 msg="login failed" user=alice
+client=1.1.1.1:4001 status=200 msg="login failed" user=alice
+client=2.2.2.2:4000 status=401 msg="login ok" user=bob
+client=3.3.3.3 status=403 msg="login failed" user=carol
+client=1.1.1.1:4002 msg="login failed" status=403 user=alice`
+	expect := `1.1.1.1 alice
+`
+	testRunDaemon(t, cfg, lines, expect)
+}
+
+func TestRateKeyWorks(t *testing.T) {
+	cfg := `
+jails:
+  - id: '{{.Name}}'
+    type: shell
+    run: |
+      nft "$1" "$GO2JAIL_user"
+watches:
+  - id: '{{.Name}}'
+    type: file
+    files: [{{.dir}}/test.log]
+disciplines:
+  - id: '{{.Name}}'
+    jails: ['{{.Name}}']
+    watches: ['{{.Name}}']
+    matches: 'user=(?P<user>\w+) rhost=%(ip)'
+    rate: 3/m
+    rate_key: '${user}'
+    arrest_all: true
+`
+	lines := `user=root rhost=1.1.1.1
+user=bob rhost=4.4.4.4
+user=root rhost=2.2.2.2
+user=root rhost=3.3.3.3
+user=root rhost=5.5.5.5`
+	expect := `3.3.3.3 root
+1.1.1.1 root
+2.2.2.2 root
+5.5.5.5 root
+`
+	testRunDaemon(t, cfg, lines, expect)
+}
+
+func TestLimiterAddMember(t *testing.T) {
+	var l Limiter
+	require.NoError(t, YamlDecode([]byte(`3/m`), &l))
+	defer l.Stop()
+	_, ok, members := l.AddMember("root", "2.2.2.2")
+	require.False(t, ok)
+	require.Nil(t, members)
+	l.AddMember("root", "1.1.1.1")
+	_, ok, members = l.AddMember("root", "2.2.2.2")
+	require.True(t, ok)
+	require.Equal(t, []string{"1.1.1.1", "2.2.2.2"}, members)
+}
+
+func TestDistinctWorks(t *testing.T) {
+	cfg := `
+jails:
+  - id: '{{.Name}}'
+    type: shell
+    run: |
+      nft "$1" "$GO2JAIL_user"
+watches:
+  - id: '{{.Name}}'
+    type: file
+    files: [{{.dir}}/test.log]
+disciplines:
+  - id: '{{.Name}}'
+    jails: ['{{.Name}}']
+    watches: ['{{.Name}}']
+    matches: 'user=(?P<user>\w+) rhost=%(ip)'
+    distinct:
+      group: user
+      min: 3
+      within: 1m
+`
+	lines := `user=alice rhost=1.1.1.1
+user=alice rhost=1.1.1.1
+user=alice rhost=1.1.1.1
+user=alice rhost=1.1.1.1
+user=root rhost=2.2.2.2
+user=admin rhost=2.2.2.2
+user=admin rhost=2.2.2.2
+user=test rhost=2.2.2.2
+user=alice rhost=1.1.1.1`
+	expect := `2.2.2.2 test
+`
+	testRunDaemon(t, cfg, lines, expect)
+}
+
+func TestEventTimeReplay(t *testing.T) {
+	cfg := `
+jails:
+  - id: '{{.Name}}'
+    type: shell
+    run: |
+      nft "$1" "$GO2JAIL_time"
+watches:
+  - id: '{{.Name}}'
+    type: file
+    files: [{{.dir}}/test.log]
+    replay: true
+disciplines:
+  - id: '{{.Name}}'
+    jails: ['{{.Name}}']
+    watches: ['{{.Name}}']
+    matches: '^(?P<time>\S+) rhost=%(ip)'
+    rate: 3/10m
+    time:
+      layout: iso8601
+`
+	lines := `2024-01-01T10:00:00Z rhost=1.1.1.1
+2024-01-01T10:11:00Z rhost=1.1.1.1
+2024-01-01T10:22:00Z rhost=1.1.1.1
+2024-01-01T10:30:00Z rhost=2.2.2.2
+2024-01-01T10:31:00Z rhost=2.2.2.2
+2024-01-01T10:32:00Z rhost=2.2.2.2`
+	expect := `2.2.2.2 2024-01-01T10:32:00Z
+`
+	testRunDaemon(t, cfg, lines, expect)
+}
+
+func TestEventTimeReplayWindows(t *testing.T) {
+	cfg := `
+jails:
+  - id: '{{.Name}}'
+    type: shell
+    run: |
+      nft "$1" "$GO2JAIL_TIME"
+watches:
+  - id: '{{.Name}}'
+    type: file
+    files: [{{.dir}}/test.log]
+    replay: true
+disciplines:
+  - id: '{{.Name}}-distinct'
+    jails: ['{{.Name}}']
+    watches: ['{{.Name}}']
+    matches: '^(?P<time>\S+) login %(ip) user=(?P<user>\w+)'
+    rate: 1/1m
+    distinct: {group: user, min: 2, within: 10m}
+    time: {layout: iso8601}
+  - id: '{{.Name}}-sequence'
+    type: sequence
+    jails: ['{{.Name}}']
+    watches: ['{{.Name}}']
+    correlate: pid
+    timeout: 1m
+    steps:
+      - '^(?P<time>\S+) connect pid=(?P<pid>\d+) %(ip)'
+      - '^(?P<time>\S+) fail pid=(?P<pid>\d+)'
+    rate: 1/1m
+    time: {layout: iso8601}
+`
+	lines := `2024-01-01T10:00:00Z login 3.3.3.3 user=a
+2024-01-01T10:20:00Z login 3.3.3.3 user=b
+2024-01-01T10:30:00Z login 4.4.4.4 user=a
+2024-01-01T10:31:00Z login 4.4.4.4 user=b
+2024-01-01T11:00:00Z connect pid=1 5.5.5.5
+2024-01-01T11:05:00Z fail pid=1
+2024-01-01T11:10:00Z connect pid=2 6.6.6.6
+2024-01-01T11:10:30Z fail pid=2`
+	expect := `4.4.4.4 2024-01-01T10:31:00Z
+6.6.6.6 2024-01-01T11:10:30Z
+`
+	testRunDaemon(t, cfg, lines, expect)
+}
+
+func TestSequenceDiscipline(t *testing.T) {
+	cfg := `
+jails:
+  - id: '{{.Name}}'
+    type: shell
+    run: |
+      nft "$1" "$GO2JAIL_pid" "$GO2JAIL_port"
+watches:
+  - id: '{{.Name}}'
+    type: file
+    files: [{{.dir}}/test.log]
+disciplines:
+  - id: '{{.Name}}'
+    type: sequence
+    jails: ['{{.Name}}']
+    watches: ['{{.Name}}']
+    correlate: pid
+    timeout: 1m
+    steps:
+      - 'sshd\[(?P<pid>\d+)\]: Connection from %(ip) port (?P<port>\d+)'
+      - 'sshd\[(?P<pid>\d+)\]: Disconnected from .* \[preauth\]'
+`
+	lines := `sshd[100]: Connection from 1.1.1.1 port 4000
+sshd[200]: Connection from 2.2.2.2 port 5000
+sshd[300]: Disconnected from 3.3.3.3 port 6000 [preauth]
+sshd[200]: Accepted publickey for bob
+sshd[100]: Disconnected from authenticating user root 1.1.1.1 port 4000 [preauth]`
+	expect := `1.1.1.1 100 4000
+`
+	testRunDaemon(t, cfg, lines, expect)
+}
+
+func TestScoreDiscipline(t *testing.T) {
+	cfg := `
+jails:
+  - id: '{{.Name}}'
+    type: shell
+    run: |
+      nft "$1" "$GO2JAIL_score"
+watches:
+  - id: '{{.Name}}'
+    type: file
+    files: [{{.dir}}/test.log]
+disciplines:
+  - id: '{{.Name}}'
+    type: score
+    jails: ['{{.Name}}']
+    watches: ['{{.Name}}']
+    threshold: 11.5
+    half_life: 1h
+    allows: ['4.4.4.4']
+    rules:
+      - matches: '^%(ip) .*"GET /wp-login\.php'
+        score: 10
+      - matches: '^%(ip) "GET (?P<path>\S+)" 404$'
+        score: 1
+    ignores:
+      path:
+        in: ['/robots.txt']
+`
+	lines := `2.2.2.2 "GET /index.html" 404
+1.1.1.1 "GET /wp-login.php" 404
+3.3.3.3 "GET /index.html" 200
+2.2.2.2 "GET /robots.txt" 404
+4.4.4.4 "GET /wp-login.php" 404
+1.1.1.1 "GET /favicon.ico" 404`
+	expect := `1.1.1.1 12.00
+`
+	testRunDaemon(t, cfg, lines, expect)
+	var buf bytes.Buffer
+	require.NoError(t, OutputScores(&buf))
+	var scores map[string]map[string]float64
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &scores))
+	require.Equal(t, map[string]float64{"2.2.2.2": 1}, scores[t.Name()])
+}
+
+func TestLogDisciplineWhenWorks(t *testing.T) {
+	cfg := `
+jails:
+  - id: '{{.Name}}'
+    type: shell
+    run: |
+      nft "$1" "$GO2JAIL_path"
+watches:
+  - id: '{{.Name}}'
+    type: file
+    files: [{{.dir}}/test.log]
+disciplines:
+  - id: '{{.Name}}'
+    jails: ['{{.Name}}']
+    watches: ['{{.Name}}']
+    matches: '^%(ip) (?P<path>\S+) (?P<status>\d+) (?P<bytes>\d+)$'
+    when: status >= 400 and bytes < 100 and not starts_with(path, '/health')
+`
+	lines := `1.1.1.1 /healthz 500 10
+2.2.2.2 /admin 200 10
+3.3.3.3 /admin 403 1000
+4.4.4.4 /admin 403 10`
+	expect := `4.4.4.4 /admin
+`
+	testRunDaemon(t, cfg, lines, expect)
+}
+
+func TestLogDisciplineGroupIgnoreWorks(t *testing.T) {
+	cfg := `
+jails:
+  - id: '{{.Name}}'
+    type: shell
+    run: |
+      nft "$1" "$GO2JAIL_user" "$GO2JAIL_path"
+watches:
+  - id: '{{.Name}}'
+    type: file
+    files: [{{.dir}}/test.log]
+disciplines:
+  - id: '{{.Name}}'
+    jails: ['{{.Name}}']
+    watches: ['{{.Name}}']
+    matches: '^%(ip) (?P<user>\w+) (?P<path>\S+)$'
+    ignores:
+      user: '^deploy$'
+      path:
+        in: ['/healthz', '/ping']
+        regex: ['^/static/']
+`
+	lines := `1.1.1.1 deploy /admin
+2.2.2.2 root /healthz
+3.3.3.3 root /static/a.css
+4.4.4.4 root /ping
+5.5.5.5 deployer /admin`
+	expect := `5.5.5.5 deployer /admin
+`
+	testRunDaemon(t, cfg, lines, expect)
+	testRequireCounters(t, "discipline", t.Name(), map[string]int{"ignore_user": 1, "ignore_path": 3})
+}
+
+func TestImportFail2ban(t *testing.T) {
+	testSetStrictConfig(t)
+	dir := t.TempDir()
+	writeTestNft(t, dir)
+	var stderr bytes.Buffer
+	oldStderr := Stderr
+	Stderr = &stderr
+	t.Cleanup(func() { Stderr = oldStderr })
+
+	out := filepath.Join(dir, "fail2ban.yaml")
+	err := runImportFail2ban(&importFail2banOptions{Output: out}, "testdata/fail2ban")
+	require.NoError(t, err)
+	warnings := stderr.String()
+	for _, s := range []string{
+		"filter mixed: failregex 1: lookahead and lookbehind are not supported",
+		"filter mixed: failregex 2: <DNS> is not supported",
+		"filter mixed: failregex 3: backreferences are not supported",
+		"filter mixed: failregex 4: unknown variable %(undefined)s",
+		"filter mixed: failregex 6: more than one host tag is not supported",
+		"filter nohost: failregex has no host",
+		"ignoreip example.com is not an ip or cidr",
+		"jail mixed: filter options ignored: [mode=aggressive]",
+		"jail mixed: action is mapped to jail nft",
+	} {
+		require.Contains(t, warnings, s)
+	}
+
+	cfg, err := Parse(out)
+	require.NoError(t, err)
+	require.Len(t, cfg.Jails, 1)
+	require.Len(t, cfg.Watches, 2)
+	require.Len(t, cfg.Disciplines, 2)
+	require.True(t, cfg.Allows.Contains(net.ParseIP("192.168.1.10")))
+
+	d := cfg.Disciplines[1]
+	require.Equal(t, "myapp", d.ID)
+	require.Equal(t, Strings{"myapp"}, d.Watches)
+	rd := d.Action.(*RegexDiscipline)
+	defer rd.Close()
+	require.Equal(t, "3/h", rd.Rate.String())
+	require.True(t, rd.AllowIP(net.ParseIP("10.1.1.1")))
+	g := rd.Matches.Match("host myapp[12]: login failed for bob from 1.2.3.4")
+	require.Equal(t, "bob", g.Get("user"))
+	require.Equal(t, "1.2.3.4", g.Get("ip"))
+	require.Equal(t, "5.6.7.8", rd.Matches.Match("myapp: bad token from 5.6.7.8 port 22").Get("ip"))
+	require.Empty(t, rd.Matches.Match("myapp: bad token from 5.6.7.8 port 22 more"))
+	_, ignored := rd.Ignores.Ignore(rd.Matches.Match("myapp: login failed for bob from 10.0.0.1"))
+	require.True(t, ignored)
+	g = rd.Matches.Match("myapp: invalid session from 5.6.7.8 (trusted)")
+	require.Equal(t, "5.6.7.8", g.Get("ip"))
+	_, ignored = rd.Ignores.Ignore(g)
+	require.True(t, ignored, "ignoreregex tests the whole line")
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Multiline joins physical lines into one logical line before they reach disciplines.
+//
+// A line matching Start begins a new event. When Continuation is set, only lines
+// matching it are appended to the current event, other lines begin a new event.
+type Multiline struct {
+	Start        *Matcher      `yaml:"start,omitempty"`
+	Continuation *Matcher      `yaml:"continuation,omitempty"`
+	MaxLines     int           `yaml:"max_lines,omitempty"`
+	Timeout      time.Duration `yaml:"timeout,omitempty"`
+	Separator    *string       `yaml:"separator,omitempty"`
+}
+
+type multilineYAML Multiline
+
+func (m *Multiline) UnmarshalYAML(b []byte) error {
+	var v multilineYAML
+	if err := YamlDecode(b, &v); err != nil {
+		return err
+	}
+	if v.Start == nil && v.Continuation == nil {
+		return errors.New("multiline: start or continuation is required")
+	}
+	if v.MaxLines <= 0 {
+		v.MaxLines = 100
+	}
+	if v.Timeout <= 0 {
+		v.Timeout = time.Second
+	}
+	if v.Separator == nil {
+		sep := "\n"
+		v.Separator = &sep
+	}
+	*m = Multiline(v)
+	return nil
+}
+
+type multilineEvent struct {
+	first Line
+	lines []string
+}
+
+func (e *multilineEvent) line(sep string) Line {
+	l := e.first
+	l.Text = strings.Join(e.lines, sep)
+	e.lines = e.lines[:0]
+	return l
+}
+
+// Assemble reads lines from in and sends logical lines to the returned channel.
+// The returned channel is closed after in is closed, pending lines are dropped
+// if ctx is done.
+func (m *Multiline) Assemble(ctx context.Context, in <-chan Line) <-chan Line {
+	out := make(chan Line)
+	go func() {
+		defer close(out)
+		var (
+			event multilineEvent
+			timer = time.NewTimer(m.Timeout)
+		)
+		defer timer.Stop()
+		timer.Stop()
+		flush := func() bool {
+			if len(event.lines) == 0 {
+				return true
+			}
+			timer.Stop()
+			select {
+			case <-ctx.Done():
+				return false
+			case out <- event.line(*m.Separator):
+				return true
+			}
+		}
+		for {
+			select {
+			case <-timer.C:
+				if !flush() {
+					return
+				}
+			case line, ok := <-in:
+				if !ok {
+					flush()
+					return
+				}
+				if !m.joinable(line.Text) && !flush() {
+					return
+				}
+				if len(event.lines) == 0 {
+					event.first = line
+				}
+				event.lines = append(event.lines, line.Text)
+				if len(event.lines) >= m.MaxLines {
+					if !flush() {
+						return
+					}
+					continue
+				}
+				timer.Reset(m.Timeout)
+			}
+		}
+	}()
+	return out
+}
+
+// joinable reports whether the line should be appended to the current event.
+func (m *Multiline) joinable(s string) bool {
+	if m.Start != nil && m.Start.Test(s) {
+		return false
+	}
+	if m.Continuation != nil {
+		return m.Continuation.Test(s)
+	}
+	return true
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/goccy/go-yaml"
+)
+
+// builtinPatterns are macros usable in all regexes as %(name).
+// Field macros capture a named group of the same meaning, e.g. %(user) captures group user.
+var builtinPatterns = map[string]string{
+	// network
+	"ipv4":   `(?:[0-9]{1,3}(?:\.[0-9]{1,3}){3})`,
+	"ipv6":   `(?:(?:[0-9a-fA-F]{0,4}:){1,7}[0-9a-fA-F]{0,4})`,
+	"ip":     `(?P<ip>(([0-9a-fA-F]{0,4}:){1,7}[0-9a-fA-F]{0,4})|([0-9]{1,3}(\.[0-9]{1,3}){3}))`,
+	"ipcidr": `(?P<ip>%(ipv6)|%(ipv4))(?:/(?P<prefix>\d{1,3}))?`,
+	"port":   `(?P<port>\d{1,5})`,
+	// hostname of syslog is not always fully qualified, allow all non-space chars.
+	"hostname": `(?:[0-9A-Za-z][0-9A-Za-z\-_.]*)`,
+
+	// common
+	"word":     `\w+`,
+	"notspace": `\S+`,
+	"int":      `[+-]?\d+`,
+	"number":   `[+-]?\d+(?:\.\d+)?`,
+	"user":     `(?P<user>\S+)`,
+	"pid":      `(?P<pid>\d+)`,
+
+	// syslog
+	"syslog_time":    `(?:[A-Z][a-z]{2}\s+\d{1,2}\s+\d{2}:\d{2}:\d{2})`,
+	"iso8601":        `(?:\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(?:\.\d+)?(?:Z|[+-]\d{2}:?\d{2})?)`,
+	"syslog_program": `(?:[\w\-./]+(?:\[\d+\])?)`,
+	"syslog_prefix":  `(?:%(syslog_time)|%(iso8601))\s+%(hostname)\s+%(syslog_program):`,
+
+	// nginx and apache access log
+	"nginx_time":      `(?:\d{2}/[A-Z][a-z]{2}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4})`,
+	"apache_time":     `%(nginx_time)`,
+	"http_method":     `(?P<method>[A-Z]+)`,
+	"http_path":       `(?P<path>\S+)`,
+	"http_version":    `(?:HTTP/\d(?:\.\d)?)`,
+	"http_request":    `%(http_method) %(http_path)(?: %(http_version))?`,
+	"http_status":     `(?P<status>\d{3})`,
+	"http_bytes":      `(?P<bytes>\d+|-)`,
+	"http_referer":    `(?P<referer>[^"]*)`,
+	"http_user_agent": `(?P<user_agent>[^"]*)`,
+	"common_log":      `%(ip) \S+ %(user) \[%(nginx_time)\] "%(http_request)" %(http_status) %(http_bytes)`,
+	"combined_log":    `%(common_log) "%(http_referer)" "%(http_user_agent)"`,
+}
+
+var (
+	patternNameRe = regexp.MustCompile(`^\w+$`)
+	patternRefRe  = regexp.MustCompile(`%\((\w+)\)`)
+
+	// parseMu serializes WithPatterns, activePatterns are patterns of the config being parsed.
+	parseMu        sync.Mutex
+	activePatterns atomic.Pointer[Patterns]
+)
+
+// Patterns are user defined macros, they override built-in ones with the same name.
+type Patterns map[string]string
+
+// Check checks patterns for names, cycles, unknown references and syntax.
+func (p Patterns) Check() error {
+	for name := range p {
+		if !patternNameRe.MatchString(name) {
+			return fmt.Errorf("bad pattern name: %q", name)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(p)) {
+		s, err := expandPattern(p[name], p.lookup, []string{name}, true)
+		if err != nil {
+			return fmt.Errorf("bad pattern %s: %w", name, err)
+		}
+		if _, err := regexp.Compile(s); err != nil {
+			return fmt.Errorf("bad pattern %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Expand replaces %(name) of known patterns, other %(...) text is kept as is.
+func (p Patterns) Expand(s string) (string, error) {
+	return expandPattern(s, p.lookup, nil, false)
+}
+
+func (p Patterns) lookup(name string) (string, bool) {
+	if v, ok := p[name]; ok {
+		return v, true
+	}
+	v, ok := builtinPatterns[name]
+	return v, ok
+}
+
+// WithPatterns runs fn with patterns p used by ExpandPatterns,
+// so that regexes decoded in fn see patterns of their own config only.
+func WithPatterns(p Patterns, fn func() error) error {
+	if err := p.Check(); err != nil {
+		return err
+	}
+	parseMu.Lock()
+	defer parseMu.Unlock()
+	activePatterns.Store(&p)
+	defer activePatterns.Store(nil)
+	return fn()
+}
+
+// ExpandPatterns expands s by patterns of WithPatterns, or built-in patterns outside of it.
+func ExpandPatterns(s string) (string, error) {
+	var p Patterns
+	if v := activePatterns.Load(); v != nil {
+		p = *v
+	}
+	return p.Expand(s)
+}
+
+// expandPattern reports unknown names if strict, otherwise they are kept.
+func expandPattern(s string, lookup func(string) (string, bool), stack []string, strict bool) (string, error) {
+	var err error
+	r := patternRefRe.ReplaceAllStringFunc(s, func(ref string) string {
+		if err != nil {
+			return ref
+		}
+		name := ref[2 : len(ref)-1]
+		if slices.Contains(stack, name) {
+			err = fmt.Errorf("pattern cycle: %s -> %s", strings.Join(stack, " -> "), name)
+			return ref
+		}
+		v, ok := lookup(name)
+		if !ok {
+			if strict {
+				err = fmt.Errorf("unknown pattern: %s", ref)
+			}
+			return ref
+		}
+		var expanded string
+		expanded, err = expandPattern(v, lookup, append(slices.Clone(stack), name), strict)
+		return expanded
+	})
+	return r, err
+}
+
+// readPatterns reads patterns of config files before they are parsed,
+// so regexes of all files could use them.
+func readPatterns(files ...string) (Patterns, error) {
+	all := Patterns{}
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("parse config fail %s: %w", f, err)
+		}
+		var v struct {
+			Patterns Patterns `yaml:"patterns"`
+		}
+		if err := yaml.Unmarshal(b, &v); err != nil {
+			return nil, fmt.Errorf("parse config fail %s: %w", f, err)
+		}
+		maps.Copy(all, v.Patterns)
+	}
+	return all, nil
+}
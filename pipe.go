@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+func init() {
+	RegisterWatcher("fifo", NewFifoWatch)
+	RegisterWatcher("stdin", NewStdinWatch)
+}
+
+// FifoWatch reads lines from a named pipe, the pipe is reopened when all writers disconnect.
+type FifoWatch struct {
+	BaseWatch `yaml:",inline"`
+	Path      string `yaml:"path"`
+	Mode      string `yaml:"mode"`
+
+	mode   uint32
+	ch     *Chan[Line]
+	ctx    context.Context
+	cancel func()
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	file   *os.File
+
+	linesCounter  *Counter
+	reopenCounter *Counter
+}
+
+func NewFifoWatch(decode Decoder) (Watcher, error) {
+	var f FifoWatch
+	if err := decode(&f); err != nil {
+		return nil, err
+	}
+	if f.Path == "" {
+		return nil, fmt.Errorf("[watch-%s] path is empty", f.ID)
+	}
+	if f.Mode == "" {
+		f.Mode = "0600"
+	}
+	mode, err := strconv.ParseUint(f.Mode, 8, 32)
+	if err != nil || mode > 0777 {
+		return nil, fmt.Errorf("[watch-%s] bad mode: %s", f.ID, f.Mode)
+	}
+	f.mode = uint32(mode)
+	f.ch = NewChan[Line](0)
+	f.ctx, f.cancel = context.WithCancel(context.Background())
+	f.linesCounter = RegisterNewCounter("watch", f.ID, "lines")
+	f.reopenCounter = RegisterNewCounter("watch", f.ID, "reopen")
+	return &f, nil
+}
+
+func (fw *FifoWatch) Watch(logger Logger) (<-chan Line, error) {
+	return fw.watch(logger, false)
+}
+
+func (fw *FifoWatch) Test(logger Logger) (<-chan Line, error) {
+	return fw.watch(logger, true)
+}
+
+func (fw *FifoWatch) setup() error {
+	stat, err := os.Stat(fw.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return mkfifo(fw.Path, fw.mode)
+	}
+	if err != nil {
+		return err
+	}
+	if stat.Mode()&fs.ModeNamedPipe == 0 {
+		return fmt.Errorf("not a named pipe: %s", fw.Path)
+	}
+	return nil
+}
+
+func (fw *FifoWatch) watch(logger Logger, testing bool) (<-chan Line, error) {
+	logger.Debugf("[watch-%s] watch starting", fw.ID)
+	if err := fw.setup(); err != nil {
+		return nil, fmt.Errorf("[watch-%s] %w", fw.ID, err)
+	}
+	ch := fw.ch
+	fw.wg.Add(1)
+	go func() {
+		defer func() {
+			ch.Close()
+			fw.wg.Done()
+		}()
+		for {
+			// open blocks until a writer comes.
+			f, err := os.OpenFile(fw.Path, os.O_RDONLY, 0)
+			if err != nil {
+				logger.Errorf("[watch-%s] open fifo fail: %v", fw.ID, err)
+				return
+			}
+			fw.mu.Lock()
+			if fw.ctx.Err() != nil {
+				fw.mu.Unlock()
+				f.Close()
+				return
+			}
+			fw.file = f
+			fw.mu.Unlock()
+			logger.Debugf("[watch-%s] fifo opened: %s", fw.ID, fw.Path)
+			err = readLines(f, func(text string) error {
+				logger.Debugf("[watch-%s] get line '%s'", fw.ID, text)
+				if err := ch.Send(NewLine(fw.ID, text)); err != nil {
+					return err
+				}
+				fw.linesCounter.Incr()
+				return nil
+			})
+			f.Close()
+			if err != nil && fw.ctx.Err() == nil {
+				logger.Errorf("[watch-%s] read fifo fail: %v", fw.ID, err)
+				return
+			}
+			if testing || fw.ctx.Err() != nil {
+				logger.Infof("[watch-%s] fifo closed: %s", fw.ID, fw.Path)
+				return
+			}
+			logger.Debugf("[watch-%s] fifo writers disconnected, reopen: %s", fw.ID, fw.Path)
+			fw.reopenCounter.Incr()
+		}
+	}()
+	return ch.Reader(), nil
+}
+
+func (fw *FifoWatch) Close() error {
+	fw.mu.Lock()
+	fw.cancel()
+	fw.ch.Close()
+	if fw.file != nil {
+		fw.file.Close()
+	}
+	fw.mu.Unlock()
+	// wake up reader blocking in open.
+	if f, err := os.OpenFile(fw.Path, os.O_WRONLY|syscall.O_NONBLOCK, 0); err == nil {
+		f.Close()
+	}
+	fw.wg.Wait()
+	return nil
+}
+
+// StdinWatch reads lines from standard input, go2jail can be the tail end of a pipeline.
+type StdinWatch struct {
+	BaseWatch `yaml:",inline"`
+
+	ch *Chan[Line]
+
+	linesCounter *Counter
+}
+
+func NewStdinWatch(decode Decoder) (Watcher, error) {
+	var s StdinWatch
+	if err := decode(&s); err != nil {
+		return nil, err
+	}
+	s.ch = NewChan[Line](0)
+	s.linesCounter = RegisterNewCounter("watch", s.ID, "lines")
+	return &s, nil
+}
+
+func (sw *StdinWatch) Watch(logger Logger) (<-chan Line, error) {
+	return sw.watch(logger)
+}
+
+func (sw *StdinWatch) Test(logger Logger) (<-chan Line, error) {
+	return sw.watch(logger)
+}
+
+func (sw *StdinWatch) watch(logger Logger) (<-chan Line, error) {
+	logger.Debugf("[watch-%s] watch starting", sw.ID)
+	ch := sw.ch
+	go func() {
+		defer ch.Close()
+		err := readLines(Stdin, func(text string) error {
+			logger.Debugf("[watch-%s] get line '%s'", sw.ID, text)
+			if err := ch.Send(NewLine(sw.ID, text)); err != nil {
+				return err
+			}
+			sw.linesCounter.Incr()
+			return nil
+		})
+		if err != nil {
+			logger.Debugf("[watch-%s] read stdin stopped: %v", sw.ID, err)
+		}
+		logger.Infof("[watch-%s] stdin closed", sw.ID)
+	}()
+	return ch.Reader(), nil
+}
+
+// Close does not wait the reader, read of stdin can not be interrupted.
+func (sw *StdinWatch) Close() error {
+	sw.ch.Close()
+	return nil
+}
+
+func readLines(r io.Reader, fn func(text string) error) error {
+	scan := bufio.NewScanner(r)
+	scan.Buffer(nil, 1024*1024)
+	for scan.Scan() {
+		if err := fn(scan.Text()); err != nil {
+			return err
+		}
+	}
+	return scan.Err()
+}
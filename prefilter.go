@@ -0,0 +1,374 @@
+package main
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Limits of literal extraction, larger sets are not worth a prefilter.
+const (
+	maxPrefilterExact = 16
+	maxPrefilterClass = 4
+)
+
+// literalInfo describes strings matched by a regex,
+// exact is the set of all strings matched if it's small,
+// otherwise any match contains at least one string of required,
+// both nil means nothing is known.
+type literalInfo struct {
+	exact    []string
+	required []string
+}
+
+func exactInfo(ss ...string) literalInfo {
+	return literalInfo{exact: ss}
+}
+
+// orSet returns strings one of which a match contains, nil if unknown.
+func (l literalInfo) orSet() []string {
+	ss := l.required
+	if l.exact != nil {
+		ss = l.exact
+	}
+	if len(ss) == 0 || slices.Contains(ss, "") {
+		return nil
+	}
+	return ss
+}
+
+// RequiredLiterals returns strings one of which any match of re contains,
+// literals are canonical case folded, see foldString. It returns nil if unknown.
+func RequiredLiterals(re *syntax.Regexp) []string {
+	ss := analyzeLiterals(re.Simplify()).orSet()
+	if ss == nil {
+		return nil
+	}
+	r := make([]string, 0, len(ss))
+	for _, s := range ss {
+		r = append(r, foldString(s))
+	}
+	slices.Sort(r)
+	return slices.Compact(r)
+}
+
+func analyzeLiterals(re *syntax.Regexp) literalInfo {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return exactInfo(string(re.Rune))
+	case syntax.OpEmptyMatch, syntax.OpBeginLine, syntax.OpEndLine,
+		syntax.OpBeginText, syntax.OpEndText, syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+		return exactInfo("")
+	case syntax.OpCharClass:
+		var ss []string
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			lo, hi := re.Rune[i], re.Rune[i+1]
+			if int(hi-lo)+1+len(ss) > maxPrefilterClass {
+				return literalInfo{}
+			}
+			for r := lo; r <= hi; r++ {
+				ss = append(ss, string(r))
+			}
+		}
+		return exactInfo(ss...)
+	case syntax.OpCapture:
+		return analyzeLiterals(re.Sub[0])
+	case syntax.OpQuest:
+		sub := analyzeLiterals(re.Sub[0])
+		if sub.exact != nil && len(sub.exact) < maxPrefilterExact {
+			return exactInfo(append(slices.Clone(sub.exact), "")...)
+		}
+		return literalInfo{}
+	case syntax.OpPlus:
+		return literalInfo{required: analyzeLiterals(re.Sub[0]).orSet()}
+	case syntax.OpRepeat:
+		if re.Min == 0 {
+			return literalInfo{}
+		}
+		return literalInfo{required: analyzeLiterals(re.Sub[0]).orSet()}
+	case syntax.OpConcat:
+		return concatLiterals(re.Sub)
+	case syntax.OpAlternate:
+		return alternateLiterals(re.Sub)
+	default:
+		return literalInfo{}
+	}
+}
+
+func concatLiterals(subs []*syntax.Regexp) literalInfo {
+	var (
+		cur      = []string{""}
+		allExact = true
+		best     []string
+	)
+	consider := func(ss []string) {
+		if ss != nil && betterLiterals(ss, best) {
+			best = ss
+		}
+	}
+	for _, sub := range subs {
+		info := analyzeLiterals(sub)
+		if info.exact != nil && len(cur)*len(info.exact) <= maxPrefilterExact {
+			cur = crossLiterals(cur, info.exact)
+			continue
+		}
+		allExact = false
+		consider(exactInfo(cur...).orSet())
+		if info.exact != nil {
+			cur = info.exact
+			continue
+		}
+		consider(info.required)
+		cur = []string{""}
+	}
+	if allExact {
+		return exactInfo(cur...)
+	}
+	consider(exactInfo(cur...).orSet())
+	return literalInfo{required: best}
+}
+
+func alternateLiterals(subs []*syntax.Regexp) literalInfo {
+	var (
+		exact    []string
+		required []string
+		allExact = true
+		unknown  bool
+	)
+	for _, sub := range subs {
+		info := analyzeLiterals(sub)
+		if info.exact == nil {
+			allExact = false
+		} else {
+			exact = append(exact, info.exact...)
+		}
+		ss := info.orSet()
+		if ss == nil {
+			unknown = true
+		}
+		required = append(required, ss...)
+	}
+	if allExact && len(exact) <= maxPrefilterExact {
+		return exactInfo(exact...)
+	}
+	if unknown {
+		return literalInfo{}
+	}
+	return literalInfo{required: required}
+}
+
+func crossLiterals(a, b []string) []string {
+	r := make([]string, 0, len(a)*len(b))
+	for _, x := range a {
+		for _, y := range b {
+			r = append(r, x+y)
+		}
+	}
+	return r
+}
+
+// betterLiterals prefers longer shortest literal, then fewer literals.
+func betterLiterals(a, b []string) bool {
+	if b == nil {
+		return true
+	}
+	minLen := func(ss []string) int {
+		n := len(ss[0])
+		for _, s := range ss[1:] {
+			n = min(n, len(s))
+		}
+		return n
+	}
+	ma, mb := minLen(a), minLen(b)
+	if ma != mb {
+		return ma > mb
+	}
+	return len(a) < len(b)
+}
+
+// foldRune returns the smallest rune of the case folding orbit of r,
+// so that strings equal under case folding have the same fold.
+func foldRune(r rune) rune {
+	if r < utf8.RuneSelf {
+		if 'a' <= r && r <= 'z' {
+			r -= 'a' - 'A'
+		}
+		return r
+	}
+	m := r
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		m = min(m, f)
+	}
+	return m
+}
+
+func foldString(s string) string {
+	return strings.Map(foldRune, s)
+}
+
+// prefilter skips regexes of a Matcher which could not match a line,
+// required literals of all regexes are scanned at once by an Aho-Corasick automaton.
+// Bytes not in any literal share one class, so states are small.
+type prefilter struct {
+	// always are indexes of regexes without required literals.
+	always []int
+	class  [256]byte
+	width  int
+	// next is the transition table of width classes per state.
+	next []int32
+	// out are indexes of regexes of which a literal ends at the state.
+	out [][]int
+	// hits pools results of candidates, one per concurrent caller.
+	hits sync.Pool
+}
+
+// newPrefilter returns nil if no regex has required literals.
+func newPrefilter(res []*regexp.Regexp) *prefilter {
+	literals := make([][]string, len(res))
+	for i, r := range res {
+		literals[i] = regexLiterals(r)
+	}
+	return newLiteralFilter(literals)
+}
+
+func regexLiterals(r *regexp.Regexp) []string {
+	re, err := syntax.Parse(r.String(), syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	return RequiredLiterals(re)
+}
+
+// newLiteralFilter returns a prefilter of which entry i is a candidate
+// if s contains one of literals[i], nil literals are always candidates.
+// It returns nil if all entries are always candidates.
+func newLiteralFilter(literals [][]string) *prefilter {
+	p := &prefilter{width: 1}
+	for i, lits := range literals {
+		if lits == nil {
+			p.always = append(p.always, i)
+			continue
+		}
+		for _, s := range lits {
+			for j := 0; j < len(s); j++ {
+				if p.class[s[j]] != 0 {
+					continue
+				}
+				if p.width == 256 {
+					return nil
+				}
+				p.class[s[j]] = byte(p.width)
+				p.width++
+			}
+		}
+	}
+	if len(p.always) == len(literals) {
+		return nil
+	}
+	n := len(literals)
+	p.hits.New = func() any {
+		hits := make([]bool, n)
+		return &hits
+	}
+	p.newState()
+	for i, lits := range literals {
+		for _, s := range lits {
+			p.insert(s, i)
+		}
+	}
+	p.build()
+	return p
+}
+
+func (p *prefilter) newState() int32 {
+	p.next = append(p.next, make([]int32, p.width)...)
+	p.out = append(p.out, nil)
+	return int32(len(p.out) - 1)
+}
+
+func (p *prefilter) insert(s string, idx int) {
+	var state int32
+	for i := 0; i < len(s); i++ {
+		t := int(state)*p.width + int(p.class[s[i]])
+		if p.next[t] == 0 {
+			n := p.newState()
+			p.next[t] = n
+		}
+		state = p.next[t]
+	}
+	if !slices.Contains(p.out[state], idx) {
+		p.out[state] = append(p.out[state], idx)
+	}
+}
+
+// build turns the trie to an automaton by breadth first search of failure links.
+func (p *prefilter) build() {
+	fail := make([]int32, len(p.out))
+	var queue []int32
+	for c := 1; c < p.width; c++ {
+		if s := p.next[c]; s != 0 {
+			queue = append(queue, s)
+		}
+	}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		f := fail[state]
+		for _, idx := range p.out[f] {
+			if !slices.Contains(p.out[state], idx) {
+				p.out[state] = append(p.out[state], idx)
+			}
+		}
+		for c := range p.width {
+			t := int(state)*p.width + c
+			s := p.next[t]
+			if s == 0 {
+				p.next[t] = p.next[int(f)*p.width+c]
+				continue
+			}
+			fail[s] = p.next[int(f)*p.width+c]
+			queue = append(queue, s)
+		}
+	}
+}
+
+// candidates reports entries which may match s by index,
+// the result must be given back by release once it's not used.
+func (p *prefilter) candidates(s string) *[]bool {
+	ptr := p.hits.Get().(*[]bool)
+	hits := *ptr
+	for _, i := range p.always {
+		hits[i] = true
+	}
+	ascii := true
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			ascii = false
+			break
+		}
+	}
+	if !ascii {
+		s = foldString(s)
+	}
+	var state int32
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ascii && 'a' <= c && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		state = p.next[int(state)*p.width+int(p.class[c])]
+		for _, idx := range p.out[state] {
+			hits[idx] = true
+		}
+	}
+	return ptr
+}
+
+func (p *prefilter) release(hits *[]bool) {
+	clear(*hits)
+	p.hits.Put(hits)
+}
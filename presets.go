@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+)
+
+//go:embed presets/*.yaml
+var presetFS embed.FS
+
+// PresetNames returns names of built-in discipline presets.
+func PresetNames() []string {
+	entries, _ := fs.ReadDir(presetFS, "presets")
+	var names []string
+	for _, e := range entries {
+		names = append(names, strings.TrimSuffix(e.Name(), ".yaml"))
+	}
+	return names
+}
+
+// Preset returns yaml of a built-in discipline preset.
+func Preset(name string) ([]byte, error) {
+	b, err := presetFS.ReadFile(path.Join("presets", name+".yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("unknown preset: %s", name)
+	}
+	return b, nil
+}
+
+// PresetDescription returns the first comment line of preset.
+func PresetDescription(b []byte) string {
+	scan := bufio.NewScanner(bytes.NewReader(b))
+	if scan.Scan() {
+		return strings.TrimSpace(strings.TrimPrefix(scan.Text(), "#"))
+	}
+	return ""
+}
+
+// applyPreset merges discipline yaml b onto its preset, top level keys of b override the preset.
+func applyPreset(name string, b []byte) ([]byte, error) {
+	p, err := Preset(name)
+	if err != nil {
+		return nil, err
+	}
+	var base, override yaml.MapSlice
+	if err := yaml.Unmarshal(p, &base); err != nil {
+		return nil, fmt.Errorf("bad preset %s: %w", name, err)
+	}
+	if err := yaml.Unmarshal(b, &override); err != nil {
+		return nil, err
+	}
+LOOP:
+	for _, item := range override {
+		for i := range base {
+			if base[i].Key == item.Key {
+				base[i].Value = item.Value
+				continue LOOP
+			}
+		}
+		base = append(base, item)
+	}
+	return yaml.Marshal(base)
+}
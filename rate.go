@@ -0,0 +1,134 @@
+package main
+
+import (
+	"time"
+)
+
+const (
+	rateFixed          = "fixed"
+	rateSlidingLog     = "sliding-log"
+	rateSlidingCounter = "sliding-counter"
+	rateTokenBucket    = "token-bucket"
+)
+
+var rateAlgorithms = map[string]func() rateState{
+	rateFixed:          func() rateState { return &fixedWindow{} },
+	rateSlidingLog:     func() rateState { return &slidingLog{} },
+	rateSlidingCounter: func() rateState { return &slidingCounter{} },
+	rateTokenBucket:    func() rateState { return &tokenBucket{} },
+}
+
+func newRateState(algorithm string) rateState {
+	if fn := rateAlgorithms[algorithm]; fn != nil {
+		return fn()
+	}
+	return &fixedWindow{}
+}
+
+// rateState counts hits of one key, hit returns the count within the window
+// including current hit.
+type rateState interface {
+	hit(now time.Time, max int, window time.Duration) int
+	expired(now time.Time, window time.Duration) bool
+}
+
+// fixedWindow starts a window at the first hit.
+type fixedWindow struct {
+	n          int
+	expiration time.Time
+}
+
+func (f *fixedWindow) hit(now time.Time, max int, window time.Duration) int {
+	if f.expiration.Before(now) {
+		f.n = 0
+		f.expiration = now.Add(window)
+	}
+	f.n++
+	return f.n
+}
+
+func (f *fixedWindow) expired(now time.Time, window time.Duration) bool {
+	return f.expiration.Before(now)
+}
+
+// slidingLog keeps time of recent hits, at most max+1 of them are kept,
+// so a limiter holds up to (max+1)*maxRateKeys times.
+type slidingLog struct {
+	hits []time.Time
+}
+
+func (s *slidingLog) hit(now time.Time, max int, window time.Duration) int {
+	since := now.Add(-window)
+	i := 0
+	for i < len(s.hits) && !s.hits[i].After(since) {
+		i++
+	}
+	s.hits = append(s.hits[i:], now)
+	if len(s.hits) > max+1 {
+		s.hits = s.hits[len(s.hits)-max-1:]
+	}
+	return len(s.hits)
+}
+
+func (s *slidingLog) expired(now time.Time, window time.Duration) bool {
+	return len(s.hits) == 0 || !s.hits[len(s.hits)-1].After(now.Add(-window))
+}
+
+// slidingCounter estimates hits by weighting the count of previous fixed window
+// with its overlap with the sliding window.
+type slidingCounter struct {
+	start    time.Time
+	current  int
+	previous int
+}
+
+func (s *slidingCounter) hit(now time.Time, max int, window time.Duration) int {
+	start := now.Truncate(window)
+	switch {
+	case start.Equal(s.start):
+	case start.Sub(s.start) == window:
+		s.previous = s.current
+		s.current = 0
+		s.start = start
+	default:
+		s.previous = 0
+		s.current = 0
+		s.start = start
+	}
+	s.current++
+	weight := 1 - float64(now.Sub(start))/float64(window)
+	return s.current + int(float64(s.previous)*weight)
+}
+
+func (s *slidingCounter) expired(now time.Time, window time.Duration) bool {
+	return now.Sub(s.start) >= window*2
+}
+
+// tokenBucket holds max tokens and refills max tokens per window,
+// each hit takes a token.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (t *tokenBucket) hit(now time.Time, max int, window time.Duration) int {
+	if t.last.IsZero() {
+		t.tokens = float64(max)
+	} else {
+		t.tokens += float64(max) * float64(now.Sub(t.last)) / float64(window)
+		if t.tokens > float64(max) {
+			t.tokens = float64(max)
+		}
+	}
+	t.last = now
+	if t.tokens < 1 {
+		return max + 1
+	}
+	t.tokens--
+	return max - int(t.tokens)
+}
+
+func (t *tokenBucket) expired(now time.Time, window time.Duration) bool {
+	// bucket is full again.
+	return now.Sub(t.last) >= window
+}
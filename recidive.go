@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+func init() {
+	RegisterDiscipliner("recidive", NewRecidiveDiscipline)
+}
+
+// ArrestListener is a discipline judging arrests of other disciplines instead of lines of watches.
+type ArrestListener interface {
+	Discipliner
+	// Sources returns ids of disciplines listened, empty means all.
+	Sources() []string
+	JudgeArrest(bad BadLog, logger Logger) (BadLog, bool)
+}
+
+// RecidiveDiscipline counts arrests of other disciplines by rate,
+// e.g. an ip arrested by sshd then nginx then postfix.
+// Arrests of listeners are not listened, so recidives never feed each other.
+// Groups of arrests are kept, and the group discipline is the id of source discipline.
+type RecidiveDiscipline struct {
+	BaseDiscipline `yaml:",inline"`
+	Disciplines    Strings `yaml:"disciplines,omitempty"`
+	RateJudge      `yaml:",inline"`
+}
+
+func NewRecidiveDiscipline(decode Decoder) (Discipliner, error) {
+	var rd RecidiveDiscipline
+	if err := decode(&rd); err != nil {
+		return nil, err
+	}
+	id := rd.ID
+	if len(rd.Watches) != 0 {
+		return nil, fmt.Errorf("[discipline-%s] recidive listens arrests and does not have watches", id)
+	}
+	if slices.Contains(rd.Disciplines, id) {
+		return nil, fmt.Errorf("[discipline-%s] recidive can not listen itself", id)
+	}
+	rd.RateJudge.Init(id)
+	return &rd, nil
+}
+
+func (rd *RecidiveDiscipline) Sources() []string {
+	return rd.Disciplines
+}
+
+// Judge never arrests, recidive has no watches.
+func (rd *RecidiveDiscipline) Judge(line Line, allow Allows, logger Logger) (BadLog, bool) {
+	return BadLog{}, false
+}
+
+func (rd *RecidiveDiscipline) JudgeArrest(bad BadLog, logger Logger) (BadLog, bool) {
+	if len(rd.Disciplines) != 0 && !slices.Contains(rd.Disciplines, bad.DisciplineID) {
+		return BadLog{}, false
+	}
+	rd.Tail()
+	groups := KeyValueList{
+		{Key: "ip", Value: bad.IP.String()},
+		{Key: "discipline", Value: bad.DisciplineID},
+	}
+	for _, kv := range bad.Extend {
+		if kv.Key != "ip" && kv.Key != "discipline" {
+			groups = append(groups, kv)
+		}
+	}
+	line := Line{WatchID: bad.WatchID, Text: bad.Line, Time: bad.Time, Replay: bad.Replay}
+	r, ok := rd.RateJudge.Judge(line, groups, Allows{}, logger)
+	if ok {
+		r.Prefix = bad.Prefix
+	}
+	return r, ok
+}
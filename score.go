@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+func init() {
+	RegisterDiscipliner("score", NewScoreDiscipline)
+}
+
+// maxScoreEntries bounds ips scored by one discipline.
+const maxScoreEntries = 65536
+
+// ScoreRule adds score to ip of lines it matches.
+type ScoreRule struct {
+	Matches *Matcher `yaml:"matches"`
+	Score   float64  `yaml:"score"`
+}
+
+// ScoreDiscipline adds scores of all matched rules to the ip,
+// scores decay by half every half_life and ip is arrested when its score reaches threshold.
+type ScoreDiscipline struct {
+	BaseDiscipline `yaml:",inline"`
+	Rules          []*ScoreRule  `yaml:"rules"`
+	Ignores        *Ignores      `yaml:"ignores,omitempty"`
+	Threshold      float64       `yaml:"threshold"`
+	HalfLife       time.Duration `yaml:"half_life,omitempty"`
+	RateJudge      `yaml:",inline"`
+
+	mu        sync.Mutex
+	scores    map[string]*ipScore
+	lastSweep time.Time
+	clock     eventClock
+}
+
+type ipScore struct {
+	score float64
+	last  time.Time
+}
+
+func NewScoreDiscipline(decode Decoder) (Discipliner, error) {
+	var sd ScoreDiscipline
+	if err := decode(&sd); err != nil {
+		return nil, err
+	}
+	id := sd.ID
+	if len(sd.Rules) == 0 {
+		return nil, fmt.Errorf("[discipline-%s] rules is empty", id)
+	}
+	for i, r := range sd.Rules {
+		if r == nil || r.Matches == nil {
+			return nil, fmt.Errorf("[discipline-%s] matches of rule %d is empty", id, i+1)
+		}
+		if err := r.Matches.ExpectGroups("ip"); err != nil {
+			return nil, fmt.Errorf("[discipline-%s] bad matches of rule %d: %w", id, i+1, err)
+		}
+	}
+	matchers := make([]*Matcher, len(sd.Rules))
+	for i, r := range sd.Rules {
+		matchers[i] = r.Matches
+	}
+	if sd.Ignores != nil {
+		if err := sd.Ignores.Init(id, matchers...); err != nil {
+			return nil, fmt.Errorf("[discipline-%s] %w", id, err)
+		}
+	}
+	err := sd.RateJudge.CheckGroups(func(group string) bool {
+		// score is added to groups once threshold is reached.
+		return group == "score" || slices.ContainsFunc(matchers, func(m *Matcher) bool { return m.HasGroup(group) })
+	})
+	if err != nil {
+		return nil, fmt.Errorf("[discipline-%s] %w in rules", id, err)
+	}
+	if sd.Threshold <= 0 {
+		return nil, fmt.Errorf("[discipline-%s] threshold must be positive", id)
+	}
+	if sd.HalfLife == 0 {
+		sd.HalfLife = 10 * time.Minute
+	}
+	sd.scores = map[string]*ipScore{}
+	sd.RateJudge.Init(id)
+	RegisterScoreBoard(id, &sd)
+	return &sd, nil
+}
+
+// RequiredLiterals is the union of rules, a line matching no rule is never judged.
+func (sd *ScoreDiscipline) RequiredLiterals() []string {
+	var r []string
+	for _, rule := range sd.Rules {
+		ss := rule.Matches.RequiredLiterals()
+		if ss == nil {
+			return nil
+		}
+		r = append(r, ss...)
+	}
+	slices.Sort(r)
+	return slices.Compact(r)
+}
+
+func (sd *ScoreDiscipline) Skip(line Line) {
+	sd.Tail()
+}
+
+func (sd *ScoreDiscipline) Judge(line Line, allow Allows, logger Logger) (bad BadLog, ok bool) {
+	sd.Tail()
+	if line.Text == "" {
+		return bad, false
+	}
+	var (
+		groups KeyValueList
+		score  float64
+	)
+	for _, r := range sd.Rules {
+		g := r.Matches.Match(line.Text)
+		if len(g) == 0 {
+			continue
+		}
+		if groups == nil {
+			groups = g
+		} else {
+			groups = mergeGroups(groups, g[1:])
+		}
+		score += r.Score
+	}
+	if len(groups) == 0 {
+		logger.Debugf("[discipline-%s][watch-%s] no rule match: length=%d", sd.ID, line.WatchID, len(line.Text))
+		return bad, false
+	}
+	if sd.Ignores != nil {
+		if rule, yes := sd.Ignores.Ignore(groups); yes {
+			logger.Debugf("[discipline-%s][watch-%s] score ignore by %s: length=%d", sd.ID, line.WatchID, rule, len(line.Text))
+			return bad, false
+		}
+	}
+	ip := net.ParseIP(groups.Get("ip"))
+	if ip == nil {
+		return sd.RateJudge.Judge(line, groups, allow, logger)
+	}
+	// allowed ips are never scored
+	if allow.Contains(ip) || sd.AllowIP(ip) {
+		sd.allowIPCount.Incr()
+		return bad, false
+	}
+	line, at := sd.eventAt(line, groups, logger)
+	total, reached := sd.add(ip.String(), score, at, logger)
+	groups = append(groups, KeyValue{Key: "score", Value: formatScore(total)})
+	if !reached {
+		logger.Infof("[discipline-%s][watch-%s] watch-on(score %s<%s): %s %s", sd.ID, line.WatchID, formatScore(total), formatScore(sd.Threshold), ip, line.Text)
+		return bad, false
+	}
+	return sd.RateJudge.Judge(line, groups, allow, logger)
+}
+
+// add adds score to ip at time now, the score is reset when it reaches threshold.
+func (sd *ScoreDiscipline) add(ip string, score float64, now time.Time, logger Logger) (float64, bool) {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+	sd.clock.observe(now)
+	sd.sweep(now)
+	s := sd.scores[ip]
+	if s == nil {
+		if len(sd.scores) >= maxScoreEntries {
+			logger.Infof("[discipline-%s] too many scored ips, drop: %s", sd.ID, ip)
+			return 0, false
+		}
+		s = &ipScore{last: now}
+		sd.scores[ip] = s
+	}
+	s.score = sd.decay(s, now) + score
+	if now.After(s.last) {
+		s.last = now
+	}
+	total := s.score
+	if total < sd.Threshold {
+		return total, false
+	}
+	delete(sd.scores, ip)
+	return total, true
+}
+
+// decay never grows the score for events older than the last one.
+func (sd *ScoreDiscipline) decay(s *ipScore, now time.Time) float64 {
+	elapsed := max(now.Sub(s.last), 0)
+	return s.score * math.Exp2(-float64(elapsed)/float64(sd.HalfLife))
+}
+
+// sweep removes ips whose score decays to nearly zero, at most once a half life.
+func (sd *ScoreDiscipline) sweep(now time.Time) {
+	if now.Sub(sd.lastSweep) < sd.HalfLife {
+		return
+	}
+	sd.lastSweep = now
+	for ip, s := range sd.scores {
+		if sd.decay(s, now) < sd.Threshold/100 {
+			delete(sd.scores, ip)
+		}
+	}
+}
+
+// Scores returns current scores of ips.
+func (sd *ScoreDiscipline) Scores() map[string]float64 {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+	now := sd.clock.now()
+	r := make(map[string]float64, len(sd.scores))
+	for ip, s := range sd.scores {
+		r[ip] = math.Round(sd.decay(s, now)*100) / 100
+	}
+	return r
+}
+
+func formatScore(f float64) string {
+	return fmt.Sprintf("%.2f", f)
+}
+
+// ScoreBoard reports scores of ips.
+type ScoreBoard interface {
+	Scores() map[string]float64
+}
+
+var globalScoreBoards sync.Map
+
+func RegisterScoreBoard(id string, b ScoreBoard) {
+	globalScoreBoards.Store(id, b)
+}
+
+// OutputScores writes scores of all score boards as json,
+// e.g. {"discipline-id": {"1.1.1.1": 12.5}}.
+func OutputScores(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	data := map[string]map[string]float64{}
+	globalScoreBoards.Range(func(k, v any) bool {
+		data[k.(string)] = v.(ScoreBoard).Scores()
+		return true
+	})
+	return enc.Encode(data)
+}
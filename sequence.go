@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+func init() {
+	RegisterDiscipliner("sequence", NewSequenceDiscipline)
+}
+
+// maxSequenceStates bounds partial sequences kept in memory.
+const maxSequenceStates = 65536
+
+// SequenceDiscipline matches ordered steps across lines,
+// steps are joined by the value of correlate group, e.g. pid or session id.
+// The ip group could come from any step, a sequence is judged when all steps are matched.
+type SequenceDiscipline struct {
+	BaseDiscipline `yaml:",inline"`
+	Steps          []*Matcher    `yaml:"steps"`
+	Correlate      string        `yaml:"correlate"`
+	Timeout        time.Duration `yaml:"timeout,omitempty"`
+	RateJudge      `yaml:",inline"`
+
+	mu        sync.Mutex
+	states    map[string]*sequenceState
+	lastSweep time.Time
+	clock     eventClock
+	startCnt  *Counter
+	expireCnt *Counter
+}
+
+type sequenceState struct {
+	next     int
+	groups   KeyValueList
+	deadline time.Time
+}
+
+func NewSequenceDiscipline(decode Decoder) (Discipliner, error) {
+	var sd SequenceDiscipline
+	if err := decode(&sd); err != nil {
+		return nil, err
+	}
+	id := sd.ID
+	if len(sd.Steps) < 2 {
+		return nil, fmt.Errorf("[discipline-%s] sequence requires at least 2 steps", id)
+	}
+	if sd.Correlate == "" {
+		return nil, fmt.Errorf("[discipline-%s] correlate is empty", id)
+	}
+	var hasIP bool
+	for i, step := range sd.Steps {
+		if step == nil {
+			return nil, fmt.Errorf("[discipline-%s] step %d is empty", id, i+1)
+		}
+		if err := step.ExpectGroups(sd.Correlate); err != nil {
+			return nil, fmt.Errorf("[discipline-%s] bad step %d: %w", id, i+1, err)
+		}
+		if step.ExpectGroups("ip") == nil {
+			hasIP = true
+		}
+	}
+	if !hasIP {
+		return nil, fmt.Errorf("[discipline-%s] no step has ip group", id)
+	}
+	err := sd.RateJudge.CheckGroups(func(group string) bool {
+		return slices.ContainsFunc(sd.Steps, func(step *Matcher) bool { return step.HasGroup(group) })
+	})
+	if err != nil {
+		return nil, fmt.Errorf("[discipline-%s] %w in steps", id, err)
+	}
+	if sd.Timeout == 0 {
+		sd.Timeout = time.Minute
+	}
+	sd.states = map[string]*sequenceState{}
+	sd.startCnt = RegisterNewCounter("discipline", id, "start_sequences")
+	sd.expireCnt = RegisterNewCounter("discipline", id, "expired_sequences")
+	sd.RateJudge.Init(id)
+	return &sd, nil
+}
+
+// RequiredLiterals is the union of steps, a line matching no step never moves a sequence.
+func (sd *SequenceDiscipline) RequiredLiterals() []string {
+	var r []string
+	for _, m := range sd.Steps {
+		ss := m.RequiredLiterals()
+		if ss == nil {
+			return nil
+		}
+		r = append(r, ss...)
+	}
+	slices.Sort(r)
+	return slices.Compact(r)
+}
+
+// Skip still sweeps timeout sequences, so expire counter keeps the same.
+func (sd *SequenceDiscipline) Skip(line Line) {
+	sd.Tail()
+	sd.mu.Lock()
+	sd.sweep(sd.clock.now())
+	sd.mu.Unlock()
+}
+
+func (sd *SequenceDiscipline) Judge(line Line, allow Allows, logger Logger) (bad BadLog, ok bool) {
+	sd.Tail()
+	if line.Text == "" {
+		return bad, false
+	}
+	groups, done := sd.advance(line, logger)
+	if !done {
+		return bad, false
+	}
+	return sd.RateJudge.Judge(line, groups, allow, logger)
+}
+
+// advance moves the sequence of line forward,
+// returns the groups of all steps when the sequence is done.
+func (sd *SequenceDiscipline) advance(line Line, logger Logger) (KeyValueList, bool) {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+	sd.sweep(sd.clock.now())
+	for i := len(sd.Steps) - 1; i >= 0; i-- {
+		groups := sd.Steps[i].Match(line.Text)
+		if len(groups) == 0 {
+			continue
+		}
+		_, now := sd.eventAt(line, groups, logger)
+		sd.clock.observe(now)
+		key := groups.Get(sd.Correlate)
+		st := sd.states[key]
+		if st != nil && !now.Before(st.deadline) {
+			sd.expire(key)
+			st = nil
+		}
+		switch {
+		case i == 0:
+			if st == nil && len(sd.states) >= maxSequenceStates {
+				logger.Infof("[discipline-%s][watch-%s] too many partial sequences, drop: %s=%s", sd.ID, line.WatchID, sd.Correlate, key)
+				return nil, false
+			}
+			sd.startCnt.Incr()
+			sd.states[key] = &sequenceState{
+				next:     1,
+				groups:   groups,
+				deadline: now.Add(sd.Timeout),
+			}
+			logger.Debugf("[discipline-%s][watch-%s] sequence start: %s=%s", sd.ID, line.WatchID, sd.Correlate, key)
+			return nil, false
+		case st == nil || st.next != i:
+			// a step out of order, try earlier steps.
+			continue
+		}
+		st.groups = mergeGroups(st.groups, groups)
+		st.next++
+		logger.Debugf("[discipline-%s][watch-%s] sequence step %d: %s=%s", sd.ID, line.WatchID, i+1, sd.Correlate, key)
+		if st.next < len(sd.Steps) {
+			return nil, false
+		}
+		delete(sd.states, key)
+		return st.groups, true
+	}
+	logger.Debugf("[discipline-%s][watch-%s] sequence not match: length=%d", sd.ID, line.WatchID, len(line.Text))
+	return nil, false
+}
+
+func (sd *SequenceDiscipline) expire(key string) {
+	delete(sd.states, key)
+	sd.expireCnt.Incr()
+}
+
+// sweep removes timeout sequences, at most once a timeout.
+func (sd *SequenceDiscipline) sweep(now time.Time) {
+	if now.Sub(sd.lastSweep) < sd.Timeout {
+		return
+	}
+	sd.lastSweep = now
+	for k, st := range sd.states {
+		if !now.Before(st.deadline) {
+			sd.expire(k)
+		}
+	}
+}
+
+// mergeGroups sets named groups of b into a, the whole match is replaced by b.
+func mergeGroups(a, b KeyValueList) KeyValueList {
+	r := append(KeyValueList{}, a...)
+	for _, kv := range b {
+		found := false
+		for i := range r {
+			if r[i].Key == kv.Key {
+				r[i].Value = kv.Value
+				found = true
+				break
+			}
+		}
+		if !found {
+			r = append(r, kv)
+		}
+	}
+	return r
+}
@@ -138,6 +138,10 @@ var setCmdUserAndGroup = func(cmd *exec.Cmd, user, group string) error {
 	return fmt.Errorf("change run user is not supported in platform: %s", runtime.GOOS)
 }
 
+var mkfifo = func(path string, mode uint32) error {
+	return fmt.Errorf("fifo is not supported in platform: %s", runtime.GOOS)
+}
+
 func NewScript(script string, opt *ScriptOption, args ...string) (*exec.Cmd, func(), error) {
 	if err := opt.SetupShell(); err != nil {
 		return nil, nil, err
@@ -244,18 +248,30 @@ func RunCmd(cmd *exec.Cmd, cancel func()) (string, error) {
 	return out, err
 }
 
-type counterStats struct {
-	n          int
-	expiration time.Time
+type Limiter struct {
+	max       int
+	timeout   time.Duration
+	algorithm string
+	mu        sync.Mutex
+	wg        sync.WaitGroup
+	mp        map[string]*rateEntry
+	members   map[string]map[string]time.Time
+	cancel    func()
+	// clock makes expiration follow event time of replayed lines.
+	clock eventClock
 }
 
-type Limiter struct {
-	max     int
-	timeout time.Duration
-	mu      sync.Mutex
-	wg      sync.WaitGroup
-	mp      map[string]*counterStats
-	cancel  func()
+// maxRateMembers bounds members recorded for one key.
+const maxRateMembers = 1024
+
+// maxRateKeys bounds keys counted by one Limiter,
+// the least recently hit keys are evicted for new keys when it's full.
+const maxRateKeys = 65536
+
+// rateEntry is the rate state of a key and the time it's last hit.
+type rateEntry struct {
+	rateState
+	last time.Time
 }
 
 func (c *Limiter) String() string {
@@ -265,14 +281,31 @@ func (c *Limiter) String() string {
 	return fmt.Sprintf("%d/%s", c.max, formatDuration(c.timeout))
 }
 
+type limiterYAML struct {
+	Limit     string `yaml:"limit"`
+	Algorithm string `yaml:"algorithm,omitempty"`
+}
+
 func (c *Limiter) MarshalYAML() (any, error) {
-	return c.String(), nil
+	if c.algorithm == "" || c.algorithm == rateFixed {
+		return c.String(), nil
+	}
+	return limiterYAML{Limit: c.String(), Algorithm: c.algorithm}, nil
 }
 
+// UnmarshalYAML accepts "5/10m" or {limit: 5/10m, algorithm: sliding-log}.
 func (c *Limiter) UnmarshalYAML(b []byte) error {
 	var s string
 	if err := yaml.Unmarshal(b, &s); err != nil {
-		return err
+		var v limiterYAML
+		if err1 := YamlDecode(b, &v); err1 != nil {
+			return err
+		}
+		if _, ok := rateAlgorithms[v.Algorithm]; !ok && v.Algorithm != "" {
+			return fmt.Errorf("unknown rate algorithm: %s", v.Algorithm)
+		}
+		s = v.Limit
+		c.algorithm = v.Algorithm
 	}
 	parts := strings.Split(s, "/")
 	if len(parts) != 2 {
@@ -310,11 +343,7 @@ func (c *Limiter) startBackground() {
 				return
 			case <-tick.C:
 				c.mu.Lock()
-				for k, v := range c.mp {
-					if v.expiration.Before(time.Now()) {
-						delete(c.mp, k)
-					}
-				}
+				c.sweepLocked(c.clock.now())
 				c.mu.Unlock()
 			}
 
@@ -323,34 +352,136 @@ func (c *Limiter) startBackground() {
 }
 
 func (c *Limiter) Add(s string) (string, bool) {
+	return c.AddAt(s, time.Now())
+}
+
+// AddAt counts s at time at, which is the event time of replayed lines.
+func (c *Limiter) AddAt(s string, at time.Time) (string, bool) {
 	if c == nil {
 		return "1/s", true
 	}
-	if c.timeout == 0 {
-		c.timeout = time.Second
+	c.mu.Lock()
+	n := c.hitLocked(at, s)
+	c.mu.Unlock()
+	ts := formatDuration(c.timeout)
+	if n >= c.max {
+		return fmt.Sprintf("%d/%s>=%d/%s", n, ts, c.max, ts), true
+	}
+	return fmt.Sprintf("%d/%s<%d/%s", n, ts, c.max, ts), false
+}
+
+// Allow reports whether s is still under the rate, a nil Limiter allows everything.
+func (c *Limiter) Allow(s string) bool {
+	if c == nil {
+		return true
 	}
+	return c.hit(s) <= c.max
+}
+
+// AddMember likes Add but also records member of s,
+// members hit within the window are returned when s is arrested.
+func (c *Limiter) AddMember(s, member string) (string, bool, []string) {
+	return c.AddMemberAt(s, member, time.Now())
+}
+
+// AddMemberAt likes AddMember but counts s at time now.
+func (c *Limiter) AddMemberAt(s, member string, now time.Time) (string, bool, []string) {
+	if c == nil {
+		return "1/s", true, []string{member}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.hitLocked(now, s)
+	if c.members == nil {
+		c.members = map[string]map[string]time.Time{}
+	}
+	mp := c.members[s]
+	if mp == nil {
+		mp = map[string]time.Time{}
+		c.members[s] = mp
+	}
+	since := now.Add(-c.timeout)
+	for k, v := range mp {
+		if !v.After(since) {
+			delete(mp, k)
+		}
+	}
+	if _, ok := mp[member]; ok || len(mp) < maxRateMembers {
+		mp[member] = now
+	}
+	ts := formatDuration(c.timeout)
+	if n >= c.max {
+		members := make([]string, 0, len(mp))
+		for k := range mp {
+			members = append(members, k)
+		}
+		slices.Sort(members)
+		delete(c.members, s)
+		return fmt.Sprintf("%d/%s>=%d/%s", n, ts, c.max, ts), true, members
+	}
+	return fmt.Sprintf("%d/%s<%d/%s", n, ts, c.max, ts), false, nil
+}
+
+func (c *Limiter) hit(s string) int {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	return c.hitLocked(time.Now(), s)
+}
+
+func (c *Limiter) hitLocked(now time.Time, s string) int {
+	if c.timeout == 0 {
+		c.timeout = time.Second
+	}
 	if c.cancel == nil {
 		c.startBackground()
 	}
 	if c.mp == nil {
-		c.mp = map[string]*counterStats{}
+		c.mp = map[string]*rateEntry{}
 	}
+	c.clock.observe(now)
 	v := c.mp[s]
-	if v == nil || v.expiration.Before(time.Now()) {
-		v = &counterStats{
-			n:          0,
-			expiration: time.Now().Add(c.timeout),
+	if v == nil {
+		if len(c.mp) >= maxRateKeys {
+			c.evictLocked(c.clock.now())
 		}
+		v = &rateEntry{rateState: newRateState(c.algorithm)}
 		c.mp[s] = v
 	}
-	v.n++
-	ts := formatDuration(c.timeout)
-	if v.n >= c.max {
-		return fmt.Sprintf("%d/%s>=%d/%s", v.n, ts, c.max, ts), true
+	if now.After(v.last) {
+		v.last = now
+	}
+	return v.hit(now, c.max, c.timeout)
+}
+
+func (c *Limiter) sweepLocked(now time.Time) {
+	for k, v := range c.mp {
+		if v.expired(now, c.timeout) {
+			delete(c.mp, k)
+			delete(c.members, k)
+		}
+	}
+}
+
+// evictLocked sweeps expired keys, if none expired,
+// the least recently hit 1/16 keys are evicted so eviction is amortized.
+func (c *Limiter) evictLocked(now time.Time) {
+	c.sweepLocked(now)
+	if len(c.mp) < maxRateKeys {
+		return
+	}
+	type keyLast struct {
+		key  string
+		last time.Time
+	}
+	keys := make([]keyLast, 0, len(c.mp))
+	for k, v := range c.mp {
+		keys = append(keys, keyLast{k, v.last})
+	}
+	slices.SortFunc(keys, func(a, b keyLast) int { return a.last.Compare(b.last) })
+	for _, k := range keys[:len(keys)/16] {
+		delete(c.mp, k.key)
+		delete(c.members, k.key)
 	}
-	return fmt.Sprintf("%d/%s<%d/%s", v.n, ts, c.max, ts), false
 }
 
 func formatDuration(d time.Duration) string {
@@ -481,6 +612,24 @@ func (c *Chan[T]) Send(v T) (err error) {
 	return nil
 }
 
+// SendDone likes Send but gives up when done is closed.
+func (c *Chan[T]) SendDone(done <-chan struct{}, v T) (err error) {
+	if c.closed {
+		return errors.New("send to closed channel")
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("send panic: %v", e)
+		}
+	}()
+	select {
+	case <-done:
+		return errors.New("send canceled")
+	case c.ch <- v:
+		return nil
+	}
+}
+
 func (c *Chan[T]) Reader() <-chan T {
 	return c.ch
 }
@@ -526,6 +675,10 @@ func (c *Counter) Incr() {
 	c.n.Add(1)
 }
 
+func (c *Counter) Add(n int64) {
+	c.n.Add(n)
+}
+
 func (c *Counter) Value() int64 {
 	return c.n.Load()
 }
@@ -570,6 +723,7 @@ func OutputCounters(w io.Writer) error {
 
 type Matcher struct {
 	regexList []*regexp.Regexp
+	prefilter *prefilter
 }
 
 func (m *Matcher) ExpectGroups(groups ...string) error {
@@ -590,11 +744,15 @@ func (m *Matcher) ExpectGroups(groups ...string) error {
 	return nil
 }
 
-var (
-	regexReplacer = strings.NewReplacer(
-		"%(ip)", `(?P<ip>(([0-9a-fA-F]{0,4}:){1,7}[0-9a-fA-F]{0,4})|([0-9]{1,3}(\.[0-9]{1,3}){3}))`,
-	)
-)
+// HasGroup reports whether any regex has the named group.
+func (m *Matcher) HasGroup(group string) bool {
+	for _, r := range m.regexList {
+		if slices.Contains(r.SubexpNames(), group) {
+			return true
+		}
+	}
+	return false
+}
 
 func (m *Matcher) MarshalYAML() (any, error) {
 	var s []string
@@ -614,21 +772,53 @@ func (m *Matcher) UnmarshalYAML(b []byte) error {
 		reList = []string{s}
 	}
 	for _, s := range reList {
-		r, err := regexp.Compile(regexReplacer.Replace(s))
+		s, err := ExpandPatterns(s)
+		if err != nil {
+			return err
+		}
+		r, err := regexp.Compile(s)
 		if err != nil {
 			return err
 		}
 		m.regexList = append(m.regexList, r)
 	}
+	m.prefilter = newPrefilter(m.regexList)
 	return nil
 }
 
+// RequiredLiterals returns folded literals one of which any match contains,
+// nil if some regex has no required literals.
+func (m *Matcher) RequiredLiterals() []string {
+	if m == nil || len(m.regexList) == 0 {
+		return nil
+	}
+	var r []string
+	for _, re := range m.regexList {
+		ss := regexLiterals(re)
+		if ss == nil {
+			return nil
+		}
+		r = append(r, ss...)
+	}
+	slices.Sort(r)
+	return slices.Compact(r)
+}
+
 func (m *Matcher) Test(s string) bool {
 	return len(m.Match(s)) > 0
 }
 
 func (m *Matcher) Match(s string) KeyValueList {
-	for _, r := range m.regexList {
+	var candidates []bool
+	if m.prefilter != nil {
+		hits := m.prefilter.candidates(s)
+		defer m.prefilter.release(hits)
+		candidates = *hits
+	}
+	for i, r := range m.regexList {
+		if candidates != nil && !candidates[i] {
+			continue
+		}
 		match := r.FindStringSubmatch(s)
 		if len(match) > 0 {
 			var groups = KeyValueList{{Value: match[0]}}
@@ -647,6 +837,44 @@ func (m *Matcher) Match(s string) KeyValueList {
 	return nil
 }
 
+// FieldTest tests a field value, all provided tests must pass.
+type FieldTest struct {
+	Equals *string  `yaml:"equals,omitempty"`
+	In     []string `yaml:"in,omitempty"`
+	Regex  *Matcher `yaml:"regex,omitempty"`
+	Min    *float64 `yaml:"min,omitempty"`
+	Max    *float64 `yaml:"max,omitempty"`
+}
+
+func (t *FieldTest) Empty() bool {
+	return t.Equals == nil && t.In == nil && t.Regex == nil && t.Min == nil && t.Max == nil
+}
+
+func (t *FieldTest) Test(s string) bool {
+	if t.Equals != nil && s != *t.Equals {
+		return false
+	}
+	if t.In != nil && !slices.Contains(t.In, s) {
+		return false
+	}
+	if t.Regex != nil && !t.Regex.Test(s) {
+		return false
+	}
+	if t.Min != nil || t.Max != nil {
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return false
+		}
+		if t.Min != nil && n < *t.Min {
+			return false
+		}
+		if t.Max != nil && n > *t.Max {
+			return false
+		}
+	}
+	return true
+}
+
 func YamlEncode(v any) string {
 	b, _ := yaml.MarshalWithOptions(v,
 		yaml.IndentSequence(true),
@@ -1,8 +1,17 @@
 package main
 
 import (
+	"fmt"
+	"io"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"regexp/syntax"
 	"sort"
+	"strconv"
 	"testing"
 	"time"
 
@@ -120,3 +129,474 @@ func TestIPLocationCache(t *testing.T) {
 	cache.Set(ip4, t.Name()+"4")
 	require.Equal(t, t.Name()+"4", cache.Get(ip4))
 }
+
+func TestParseKmsg(t *testing.T) {
+	r, err := parseKmsg("14,339,5140900,-;NET: Registered protocol family 10\n SUBSYSTEM=net\n")
+	require.NoError(t, err)
+	require.Equal(t, kmsgRecord{
+		Level:     6,
+		Facility:  1,
+		Seq:       339,
+		Timestamp: 5140900 * time.Microsecond,
+		Message:   "NET: Registered protocol family 10",
+	}, r)
+	_, err = parseKmsg("6,339;no timestamp")
+	require.Error(t, err)
+	_, err = parseKmsg("no header")
+	require.Error(t, err)
+}
+
+func TestParseLogfmt(t *testing.T) {
+	pairs := parseLogfmt(`ts=2025-01-01T00:00:00Z level=info msg="bad \"login\"\tnow" user= flag  ip=1.1.1.1 =skip`)
+	require.Equal(t, KeyValueList{
+		{Key: "ts", Value: "2025-01-01T00:00:00Z"},
+		{Key: "level", Value: "info"},
+		{Key: "msg", Value: "bad \"login\"\tnow"},
+		{Key: "user", Value: ""},
+		{Key: "flag", Value: ""},
+		{Key: "ip", Value: "1.1.1.1"},
+	}, pairs)
+	require.Nil(t, parseLogfmt("  "))
+}
+
+func TestRateAlgorithms(t *testing.T) {
+	start := time.Unix(1000, 0)
+	window := 10 * time.Second
+	// 3 hits per 10s, each hit 6s apart.
+	hits := func(algorithm string) []int {
+		st := newRateState(algorithm)
+		var r []int
+		for i := range 4 {
+			r = append(r, st.hit(start.Add(time.Duration(i)*6*time.Second), 3, window))
+		}
+		return r
+	}
+	require.Equal(t, []int{1, 2, 1, 2}, hits(rateFixed))
+	require.Equal(t, []int{1, 2, 2, 2}, hits(rateSlidingLog))
+	require.Equal(t, []int{1, 1, 1, 1}, hits(rateTokenBucket))
+
+	st := newRateState(rateSlidingLog)
+	for i := range 10 {
+		require.Equal(t, min(i+1, 4), st.hit(start, 3, window))
+	}
+	require.False(t, st.expired(start.Add(window-1), window))
+	require.True(t, st.expired(start.Add(window), window))
+
+	st = newRateState(rateTokenBucket)
+	require.Equal(t, []int{1, 2, 3, 4, 4}, []int{
+		st.hit(start, 3, window),
+		st.hit(start, 3, window),
+		st.hit(start, 3, window),
+		st.hit(start, 3, window),
+		st.hit(start, 3, window),
+	})
+	require.Equal(t, 3, st.hit(start.Add(window/2), 3, window))
+
+	st = newRateState(rateSlidingCounter)
+	require.Equal(t, 1, st.hit(time.Unix(1000, 0), 3, window))
+	require.Equal(t, 2, st.hit(time.Unix(1001, 0), 3, window))
+	// previous window has 2 hits, half of them counted.
+	require.Equal(t, 2, st.hit(time.Unix(1015, 0), 3, window))
+	require.True(t, st.expired(time.Unix(1030, 0), window))
+}
+
+func TestLimiterYAML(t *testing.T) {
+	var l Limiter
+	require.NoError(t, YamlDecode([]byte(`5/10m`), &l))
+	require.Equal(t, "5/10m", l.String())
+	require.Equal(t, "", l.algorithm)
+
+	require.NoError(t, YamlDecode([]byte("limit: 5/m\nalgorithm: token-bucket"), &l))
+	require.Equal(t, "5/m", l.String())
+	require.Equal(t, rateTokenBucket, l.algorithm)
+	v, err := l.MarshalYAML()
+	require.NoError(t, err)
+	require.Equal(t, limiterYAML{Limit: "5/m", Algorithm: rateTokenBucket}, v)
+
+	require.Error(t, YamlDecode([]byte("limit: 5/m\nalgorithm: bad"), &l))
+	l.Stop()
+}
+
+func TestLimiterMaxKeys(t *testing.T) {
+	var l Limiter
+	require.NoError(t, YamlDecode([]byte("limit: 2/m\nalgorithm: sliding-log"), &l))
+	defer l.Stop()
+	now := time.Now()
+	for i := range maxRateKeys {
+		l.AddAt(strconv.Itoa(i), now.Add(time.Duration(i)*time.Microsecond))
+	}
+	now = now.Add(time.Second)
+	_, arrest := l.AddAt("new", now)
+	require.False(t, arrest)
+	_, arrest = l.AddAt("new", now)
+	require.True(t, arrest, "new keys are counted when full")
+	require.LessOrEqual(t, len(l.mp), maxRateKeys)
+	require.NotContains(t, l.mp, "0", "least recently hit key is evicted")
+	require.Contains(t, l.mp, strconv.Itoa(maxRateKeys-1))
+}
+
+func TestExpr(t *testing.T) {
+	groups := KeyValueList{
+		{Key: "status", Value: "404"},
+		{Key: "bytes", Value: "12"},
+		{Key: "path", Value: "/Wp-Login.php"},
+		{Key: "method", Value: "POST"},
+		{Key: "ip", Value: "10.1.2.3"},
+	}
+	for _, c := range []struct {
+		expr   string
+		expect bool
+	}{
+		{`status >= 400 and bytes < 100 and not starts_with(path, '/health')`, true},
+		{`status == 404 && bytes * 2 + 1 == 25`, true},
+		{`status % 100 == 4 || false`, true},
+		{`-bytes < 0 and (status - 4) / 100 == 4`, true},
+		{`status != "404"`, false},
+		{`lower(path) == '/wp-login.php' and len(path) == 13`, true},
+		{`contains(upper(path), 'LOGIN') and ends_with(path, '.php')`, true},
+		{`matches(path, '(?i)^/wp-')`, true},
+		{`method in ['GET', 'HEAD']`, false},
+		{`method not in ['GET', 'HEAD']`, true},
+		{`status in [401, 404]`, true},
+		{`cidr(ip, '192.168.0.0/16', '10.0.0.0/8')`, true},
+		{`cidr(ip, '10.1.2.4')`, false},
+		{`cidr(missing, '10.0.0.0/8')`, false},
+		{`missing == '' and trim(' a ') == 'a'`, true},
+		{`path + method == '/Wp-Login.phpPOST' and str(num('1.50')) == '1.5'`, true},
+		{`!(status < 400)`, true},
+	} {
+		e, err := CompileExpr(c.expr)
+		require.NoError(t, err, c.expr)
+		got, err := e.Eval(groups)
+		require.NoError(t, err, c.expr)
+		require.Equal(t, c.expect, got, c.expr)
+	}
+
+	e, err := CompileExpr(`path > 400`)
+	require.NoError(t, err)
+	_, err = e.Eval(groups)
+	require.Error(t, err)
+	e, err = CompileExpr(`status and bytes > 1`)
+	require.NoError(t, err)
+	require.Equal(t, []string{"bytes", "status"}, e.Vars())
+	_, err = e.Eval(groups)
+	require.Error(t, err)
+
+	for _, bad := range []string{
+		`status >=`,
+		`status == 'abc`,
+		`foo(status)`,
+		`len(a, b)`,
+		`matches(path, method)`,
+		`matches(path, '(')`,
+		`cidr(ip, 'abc')`,
+		`(status == 1`,
+		`status == 1 1`,
+		`status # 1`,
+	} {
+		_, err := CompileExpr(bad)
+		require.Error(t, err, bad)
+	}
+}
+
+func TestPatterns(t *testing.T) {
+	var m Matcher
+	err := WithPatterns(Patterns{
+		"sshd_user": `(?:invalid user )?%(user)`,
+		"sshd_fail": `Failed password for %(sshd_user) from %(ip) port %(port)`,
+	}, func() error {
+		return YamlDecode([]byte(`'%(syslog_prefix) %(sshd_fail)'`), &m)
+	})
+	require.NoError(t, err)
+	g := m.Match("Jan  2 03:04:05 host sshd[123]: Failed password for invalid user bob from 1.2.3.4 port 22")
+	require.Equal(t, "bob", g.Get("user"))
+	require.Equal(t, "1.2.3.4", g.Get("ip"))
+	require.Equal(t, "22", g.Get("port"))
+	// patterns are scoped to WithPatterns.
+	s, err := ExpandPatterns(`%(sshd_fail)`)
+	require.NoError(t, err)
+	require.Equal(t, `%(sshd_fail)`, s)
+
+	m = Matcher{}
+	require.NoError(t, YamlDecode([]byte(`'^%(combined_log)$'`), &m))
+	g = m.Match(`1.2.3.4 - - [02/Jan/2025:03:04:05 +0800] "GET /wp-login.php HTTP/1.1" 404 12 "-" "curl/8.0"`)
+	require.Equal(t, "1.2.3.4", g.Get("ip"))
+	require.Equal(t, "GET", g.Get("method"))
+	require.Equal(t, "/wp-login.php", g.Get("path"))
+	require.Equal(t, "404", g.Get("status"))
+	require.Equal(t, "curl/8.0", g.Get("user_agent"))
+
+	m = Matcher{}
+	require.NoError(t, YamlDecode([]byte(`'deny %(ipcidr)'`), &m))
+	g = m.Match("deny 10.0.0.0/8")
+	require.Equal(t, "10.0.0.0", g.Get("ip"))
+	require.Equal(t, "8", g.Get("prefix"))
+
+	// unknown names in regexes are literal text, as before patterns.
+	m = Matcher{}
+	require.NoError(t, YamlDecode([]byte(`'%(nope) %(ip)'`), &m))
+	require.Equal(t, "1.1.1.1", m.Match("%nope 1.1.1.1").Get("ip"))
+
+	require.ErrorContains(t, Patterns{"self": `a%(self)`}.Check(), "cycle")
+	require.ErrorContains(t, Patterns{"a": `%(b)`, "b": `%(c)`, "c": `%(a)`}.Check(), "cycle")
+	require.ErrorContains(t, Patterns{"a": `%(nope)`}.Check(), "unknown pattern")
+	require.Error(t, Patterns{"a": `(`}.Check())
+	require.Error(t, Patterns{"a-b": `x`}.Check())
+}
+
+func TestParseFail2banTime(t *testing.T) {
+	for s, d := range map[string]time.Duration{
+		"600":     10 * time.Minute,
+		"10m":     10 * time.Minute,
+		"1h30m":   90 * time.Minute,
+		"2 hours": 2 * time.Hour,
+		"1d":      24 * time.Hour,
+		"1w":      7 * 24 * time.Hour,
+		"30s":     30 * time.Second,
+		"5mins":   5 * time.Minute,
+	} {
+		v, err := parseFail2banTime(s)
+		require.NoError(t, err, s)
+		require.Equal(t, d, v, s)
+	}
+	_, err := parseFail2banTime("1x")
+	require.Error(t, err)
+}
+
+func TestEventTime(t *testing.T) {
+	now := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		layout, s string
+		expect    time.Time
+	}{
+		{"syslog", "Jan  1 15:04:05", time.Date(2024, 1, 1, 15, 4, 5, 0, time.UTC)},
+		{"syslog", "Dec 31 23:59:59", time.Date(2023, 12, 31, 23, 59, 59, 0, time.UTC)},
+		{"iso8601", "2024-01-01T15:04:05+08:00", time.Date(2024, 1, 1, 7, 4, 5, 0, time.UTC)},
+		{"iso8601", "2024-01-01 15:04:05.5", time.Date(2024, 1, 1, 15, 4, 5, 5e8, time.UTC)},
+		{"nginx", "01/Jan/2024:15:04:05 +0000", time.Date(2024, 1, 1, 15, 4, 5, 0, time.UTC)},
+		{"epoch", "1704121445.5", time.Date(2024, 1, 1, 15, 4, 5, 5e8, time.UTC)},
+		{"2006/01/02 15:04", "2024/01/01 15:04", time.Date(2024, 1, 1, 15, 4, 0, 0, time.UTC)},
+	} {
+		var e EventTime
+		require.NoError(t, YamlDecode([]byte(fmt.Sprintf("{layout: '%s', timezone: UTC}", c.layout)), &e))
+		require.Equal(t, "time", e.Group)
+		v, err := e.Parse(c.s, now)
+		require.NoError(t, err, c.s)
+		require.True(t, c.expect.Equal(v), "%s: %s", c.s, v)
+	}
+	var e EventTime
+	require.NoError(t, YamlDecode([]byte(`{layout: nginx}`), &e))
+	_, err := e.Parse("2024-01-01", now)
+	require.Error(t, err)
+	require.Error(t, YamlDecode([]byte(`{timezone: Nowhere/City}`), &e))
+}
+
+func TestLimiterAddAt(t *testing.T) {
+	var l Limiter
+	require.NoError(t, YamlDecode([]byte(`3/10m`), &l))
+	defer l.Stop()
+	at := time.Now().Add(-24 * time.Hour)
+	for i := range 3 {
+		_, ok := l.AddAt("a", at.Add(time.Duration(i)*11*time.Minute))
+		require.False(t, ok)
+	}
+	for i := range 3 {
+		_, ok := l.AddAt("b", at.Add(time.Duration(i)*time.Minute))
+		require.Equal(t, i == 2, ok)
+	}
+}
+
+func TestAllows(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "trusted.txt")
+	require.NoError(t, os.WriteFile(file, []byte("# office\n10.1.0.0/16\n2001:db8::1 # vpn\n"), 0644))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"hooks": ["192.30.252.0/22", "2606:50c0::/32", "0.0.0.0/0", "2000::/3"], "verifiable_password_authentication": false}`)
+	}))
+	defer server.Close()
+
+	var a Allows
+	require.NoError(t, YamlDecode([]byte(fmt.Sprintf(`
+- 172.16.0.0/12
+- 8.8.8.8
+- file:%s
+- source: url:%s
+  refresh: 1h
+  insecure: true
+`, file, server.URL)), &a))
+	defer a.Stop()
+	require.Len(t, a.Entries(), 4)
+	require.True(t, a.Contains(net.ParseIP("172.20.1.1")))
+	require.True(t, a.Contains(net.ParseIP("8.8.8.8")))
+	require.False(t, a.Contains(net.ParseIP("8.8.4.4")))
+	require.False(t, a.Contains(net.ParseIP("10.1.2.3")))
+
+	a.Start(NewLogger(LevelError, io.Discard))
+	require.True(t, a.Contains(net.ParseIP("10.1.2.3")))
+	require.True(t, a.Contains(net.ParseIP("2001:db8::1")))
+	require.False(t, a.Contains(net.ParseIP("2001:db8::2")))
+	require.True(t, a.Contains(net.ParseIP("192.30.253.1")))
+	require.True(t, a.Contains(net.ParseIP("2606:50c0:1::1")))
+	require.False(t, a.Contains(net.ParseIP("10.2.0.1")))
+	require.True(t, a.Contains(net.ParseIP("127.0.0.1")))
+	// too short networks of urls are dropped.
+	require.False(t, a.Contains(net.ParseIP("9.9.9.9")))
+	require.False(t, a.Contains(net.ParseIP("2001:4860::1")))
+
+	require.ErrorContains(t, YamlDecode([]byte(`[url:http://example.com]`), &Allows{}), "use https")
+	require.Error(t, YamlDecode([]byte(`[url:ftp://example.com]`), &Allows{}))
+	require.Error(t, YamlDecode([]byte(`[10.0.0.0/33]`), &Allows{}))
+}
+
+func TestParseIPList(t *testing.T) {
+	nets := ParseIPList([]byte("; Spamhaus DROP\n1.10.16.0/20 ; SBL256894\n\n2.2.2.2,100,2024-01-01\nbad\n"))
+	var ss []string
+	for _, n := range nets {
+		ss = append(ss, n.String())
+	}
+	require.Equal(t, []string{"1.10.16.0/20", "2.2.2.2/32"}, ss)
+}
+
+func TestIPSet(t *testing.T) {
+	var s IPSet
+	require.NoError(t, YamlDecode([]byte(`[10.0.0.0/8, 10.1.0.0/16, 10.1.2.3, '2001:db8::/32', '::ffff:192.168.0.0/112']`), &s))
+	require.Equal(t, 5, s.Len())
+	lookup := func(ip string) string {
+		p, ok := s.Lookup(netip.MustParseAddr(ip))
+		if !ok {
+			return ""
+		}
+		return p.String()
+	}
+	require.Equal(t, "10.1.2.3/32", lookup("10.1.2.3"))
+	require.Equal(t, "10.1.0.0/16", lookup("10.1.2.4"))
+	require.Equal(t, "10.0.0.0/8", lookup("10.2.0.1"))
+	require.Equal(t, "192.168.0.0/16", lookup("::ffff:192.168.1.1"))
+	require.Equal(t, "2001:db8::/32", lookup("2001:db8::1"))
+	require.Equal(t, "", lookup("11.0.0.1"))
+	require.True(t, s.ContainsIP(net.ParseIP("10.3.0.1")))
+	require.False(t, s.ContainsIP(nil))
+
+	require.False(t, s.Remove(netip.MustParsePrefix("10.1.0.0/24")))
+	require.True(t, s.Remove(netip.MustParsePrefix("10.1.0.0/16")))
+	require.Equal(t, "10.0.0.0/8", lookup("10.1.2.4"))
+	require.True(t, s.Remove(netip.MustParsePrefix("10.0.0.0/8")))
+	require.Equal(t, "", lookup("10.1.2.4"))
+	require.Equal(t, "10.1.2.3/32", lookup("10.1.2.3"))
+	require.True(t, s.Has(netip.MustParsePrefix("10.1.2.3/32")))
+	require.False(t, s.Add(netip.MustParsePrefix("10.1.2.3/32")))
+	require.True(t, s.Overlaps(netip.MustParsePrefix("10.0.0.0/8")))
+	require.True(t, s.Overlaps(netip.MustParsePrefix("192.168.1.0/24")))
+	require.False(t, s.Overlaps(netip.MustParsePrefix("10.2.0.0/16")))
+	require.False(t, s.Overlaps(netip.MustParsePrefix("2001:db9::/32")))
+	p, err := ParsePrefix("::ffff:0.0.0.0/80")
+	require.NoError(t, err)
+	require.Equal(t, "::/80", p.String())
+	require.False(t, NewIPSet(p).Contains(netip.MustParseAddr("1.1.1.1")))
+
+	o := NewIPSet(netip.MustParsePrefix("1.1.1.0/24"), netip.MustParsePrefix("10.1.2.3/32"))
+	s.Merge(o)
+	require.Equal(t, "1.1.1.0/24,10.1.2.3/32,192.168.0.0/16,2001:db8::/32", s.String())
+	var r IPSet
+	require.NoError(t, YamlDecode([]byte(YamlEncode(&s)), &r))
+	require.Equal(t, s.String(), r.String())
+}
+
+func TestRequiredLiterals(t *testing.T) {
+	for s, expect := range map[string][]string{
+		`authentication\s+failure.+?rhost=%(ip)`: {"AUTHENTICATION"},
+		`Failed (password|publickey) for`:        {"FAILED PASSWORD FOR", "FAILED PUBLICKEY FOR"},
+		`"(?i:sqlmap|nikto)[^"]*"`:               {"\"NIKTO", "\"SQLMAP"},
+		`x[ab]y`:                                 {"XAY", "XBY"},
+		`ab?c`:                                   {"ABC", "AC"},
+		`.*`:                                     nil,
+		`a*`:                                     nil,
+		`foo|.*`:                                 nil,
+		`(?:abc)+\d{3}(?:xyz){2}`:                {"XYZXYZ"},
+		`^%(ip) \S+ \S+ \[%(nginx_time)\] "(GET|POST) /wp-login\.php.*" 4`: {"] \"GET /WP-LOGIN.PHP", "] \"POST /WP-LOGIN.PHP"},
+	} {
+		e, err := ExpandPatterns(s)
+		require.NoError(t, err)
+		re, err := syntax.Parse(e, syntax.Perl)
+		require.NoError(t, err)
+		require.Equal(t, expect, RequiredLiterals(re), s)
+	}
+	var d Discipline
+	require.NoError(t, YamlDecode([]byte(`
+id: required-literals
+type: score
+threshold: 1
+rules:
+  - {matches: 'zzz %(ip)', score: 1}
+  - {matches: 'aaa %(ip)', score: 1}
+  - {matches: 'zzz %(ip)', score: 2}
+`), &d))
+	defer d.Action.Close()
+	require.Equal(t, []string{"AAA ", "ZZZ "}, d.Action.(*ScoreDiscipline).RequiredLiterals())
+}
+
+func TestMatcherPrefilter(t *testing.T) {
+	var m Matcher
+	require.NoError(t, YamlDecode([]byte(`
+- 'Failed password for %(user) from %(ip)'
+- '(?i)invalid USER %(user) from %(ip)'
+- '(?i)ſtrange (?P<ip>x)'
+- '^(?P<ip>\d+)$'
+`), &m))
+	require.NotNil(t, m.prefilter)
+	for _, c := range []struct {
+		line, ip string
+	}{
+		{"Failed password for root from 1.1.1.1", "1.1.1.1"},
+		{"failed password for root from 1.1.1.1", ""},
+		{"Invalid user bob from 2.2.2.2", "2.2.2.2"},
+		{"INVALID USER bob from 2.2.2.2", "2.2.2.2"},
+		{"STRANGE x", "x"},
+		{"ſtrange x", "x"},
+		{"12345", "12345"},
+		{"Accepted password for root from 1.1.1.1", ""},
+	} {
+		require.Equal(t, c.ip, m.Match(c.line).Get("ip"), c.line)
+	}
+}
+
+// benchmarkMatcher reports allocations, lines not matched allocate nothing by prefilter.
+func benchmarkMatcher(b *testing.B, prefilter, miss bool) {
+	var m Matcher
+	require.NoError(b, YamlDecode([]byte(`
+- '^%(ip) \S+ \S+ \[%(nginx_time)\] "[^"]*" \d{3} \S+ "[^"]*" "(?P<user_agent>[^"]*(?i:sqlmap|nikto|nmap|masscan|zgrab|nuclei)[^"]*)"'
+- '^%(ip) \S+ \S+ \[%(nginx_time)\] "(?:GET|POST) /wp-login\.php[^"]*" 40[134] '
+- '^%(ip) \S+ \S+ \[%(nginx_time)\] "POST /xmlrpc\.php[^"]*" \d{3} '
+- 'authentication failure.+rhost=%(ip)'
+`), &m))
+	if !prefilter {
+		m.prefilter = nil
+	}
+	lines := []string{
+		`1.1.1.1 - - [10/Oct/2024:13:55:36 +0000] "GET /index.html HTTP/1.1" 200 2326 "-" "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36"`,
+		`2.2.2.2 - - [10/Oct/2024:13:55:37 +0000] "GET /static/app.js HTTP/1.1" 200 5120 "https://example.com/" "Mozilla/5.0 (Windows NT 10.0; Win64; x64)"`,
+		`3.3.3.3 - - [10/Oct/2024:13:55:38 +0000] "GET /api/items?page=2 HTTP/1.1" 200 812 "-" "curl/8.4.0"`,
+		`4.4.4.4 - - [10/Oct/2024:13:55:39 +0000] "GET /.env HTTP/1.1" 404 153 "-" "Mozilla/5.0 (compatible; Nmap Scripting Engine)"`,
+	}
+	if miss {
+		lines = lines[:3]
+	}
+	var size int64
+	for _, l := range lines {
+		size += int64(len(l))
+	}
+	b.SetBytes(size)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		for _, l := range lines {
+			m.Match(l)
+		}
+	}
+}
+
+func BenchmarkMatcher(b *testing.B) {
+	b.Run("regex", func(b *testing.B) { benchmarkMatcher(b, false, false) })
+	b.Run("prefilter", func(b *testing.B) { benchmarkMatcher(b, true, false) })
+	b.Run("prefilter-miss", func(b *testing.B) { benchmarkMatcher(b, true, true) })
+}
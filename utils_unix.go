@@ -12,6 +12,7 @@ import (
 
 func init() {
 	setCmdUserAndGroup = setCmdUserAndGroupLinux
+	mkfifo = syscall.Mkfifo
 }
 
 func setCmdUserAndGroupLinux(cmd *exec.Cmd, username, group string) error {
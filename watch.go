@@ -50,6 +50,12 @@ func NewFileWatch(decode Decoder) (Watcher, error) {
 }
 
 func (fd *FileWatch) tail(f string, testing bool) (t *tail.Tail, err error) {
+	return tailFile(f, testing, false)
+}
+
+// tailFile follows f from its current end, or from the beginning when
+// fromStart is set. In testing mode the file is read once from the beginning.
+func tailFile(f string, testing, fromStart bool) (t *tail.Tail, err error) {
 	cfg := tail.Config{
 		Location: &tail.SeekInfo{
 			Offset: 0,
@@ -64,6 +70,15 @@ func (fd *FileWatch) tail(f string, testing bool) (t *tail.Tail, err error) {
 		cfg.Location = nil
 		cfg.Follow = false
 		cfg.ReOpen = false
+	} else if fromStart {
+		cfg.Location = nil
+	} else if stat, err := os.Stat(f); err == nil {
+		// tail seeks in background, pin the end offset now so that
+		// lines written right after the watch started are not skipped.
+		cfg.Location = &tail.SeekInfo{
+			Offset: stat.Size(),
+			Whence: io.SeekStart,
+		}
 	}
 	for range 3 {
 		t, err = tail.TailFile(f, cfg)
@@ -153,6 +168,7 @@ type ShellWatch struct {
 	RestartPolicy *RestartPolicy `yaml:"restart_policy"`
 
 	ch     *Chan[string]
+	lines  *Chan[Line]
 	wg     sync.WaitGroup
 	ctx    context.Context
 	cancel func()
@@ -175,6 +191,7 @@ func NewShellWatch(decode Decoder) (Watcher, error) {
 	}
 	s.ShellOutput = ""
 	s.ch = NewChan[string](0)
+	s.lines = NewChan[Line](0)
 	s.ctx, s.cancel = context.WithCancel(context.Background())
 	s.linesCounter = RegisterNewCounter("watch", s.ID, "lines")
 	s.restartCounter = RegisterNewCounter("watch", s.ID, "restart")
@@ -203,7 +220,8 @@ func (sd *ShellWatch) watch(logger Logger, test bool) (<-chan Line, error) {
 	sd.wg.Add(1)
 	go func() {
 		defer func() {
-			sd.clean()
+			// let the line reader drain what is left before closing.
+			sd.ch.Close()
 			sd.wg.Done()
 		}()
 		waitExit := func() error {
@@ -230,11 +248,11 @@ func (sd *ShellWatch) watch(logger Logger, test bool) (<-chan Line, error) {
 		}
 		logger.Infof("[watch-%s] exec exit by restart_policy: exiterr=%v", sd.ID, exeErr)
 	}()
-	ch := NewChan[Line](0)
+	ch := sd.lines
 	sd.wg.Add(1)
 	go func() {
 		defer func() {
-			sd.clean()
+			ch.Close()
 			sd.wg.Done()
 		}()
 		rd := sd.ch.Reader()
@@ -292,6 +310,7 @@ func (sd *ShellWatch) execute(logger Logger, test bool) (cmd *exec.Cmd, cancel f
 func (sd *ShellWatch) clean() {
 	sd.cancel()
 	sd.ch.Close()
+	sd.lines.Close()
 }
 
 func (sd *ShellWatch) Close() error {